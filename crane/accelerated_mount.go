@@ -7,6 +7,12 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	acceleratedMountLabel       = "com.crane-orchestration.accelerated-mount"
+	acceleratedMountConfigLabel = "com.crane-orchestration.accelerated-mount-config"
 )
 
 type AcceleratedMount interface {
@@ -15,6 +21,7 @@ type AcceleratedMount interface {
 	Logs(follow bool)
 	VolumeArg() string
 	Volume() string
+	Prune(olderThan time.Duration)
 }
 
 type acceleratedMount struct {
@@ -74,7 +81,8 @@ func (am *acceleratedMount) ensureDataVolume() {
 			"volume",
 			"create",
 			"--name", am.dataVolumeName(),
-			"--label", "com.crane-orchestration.accelerated-mount=" + am.Volume(),
+			"--label", acceleratedMountLabel + "=" + am.Volume(),
+			"--label", acceleratedMountConfigLabel + "=" + am.configPath,
 		}
 		executeHiddenCommand("docker", args)
 	}
@@ -151,7 +159,8 @@ func (am *acceleratedMount) syncContainerArgs() []string {
 		"-v", am.dataVolumeName() + ":/data-volume",
 		"-e", "UNISON_UID=" + strconv.Itoa(am.Uid),
 		"-e", "UNISON_GID=" + strconv.Itoa(am.Gid),
-		"--label", "com.crane-orchestration.accelerated-mount=" + am.Volume(),
+		"--label", acceleratedMountLabel + "=" + am.Volume(),
+		"--label", acceleratedMountConfigLabel + "=" + am.configPath,
 		am.image(),
 	}
 }
@@ -194,6 +203,54 @@ func (am *acceleratedMount) bindMountContainerPart() string {
 	return parts[1]
 }
 
+// Remove stopped sync containers of the accelerated mount, as well as
+// data volumes which are not used by any container anymore. This
+// includes those of earlier configurations of the mount, but not those
+// of other projects. Mounts still in use are started again (and synced
+// initially) when needed.
+func (am *acceleratedMount) Prune(olderThan time.Duration) {
+	labelFilter := "label=" + acceleratedMountLabel + "=" + am.Volume()
+	args := []string{
+		"ps", "--all", "--quiet",
+		"--filter", "status=created",
+		"--filter", "status=exited",
+		"--filter", "status=dead",
+		"--filter", labelFilter,
+	}
+	output, _ := commandOutput("docker", args)
+	for _, id := range strings.Fields(output) {
+		fields := strings.Split(inspectString(id, "{{.Name}}+++{{.Created}}+++{{index .Config.Labels \""+acceleratedMountConfigLabel+"\"}}"), "+++")
+		if len(fields) != 3 || !createdBefore(fields[1], olderThan) || !am.ownsHelper(fields[2]) {
+			continue
+		}
+		printInfof("Removing sync container %s ...\n", strings.TrimPrefix(fields[0], "/"))
+		executeHiddenCommand("docker", []string{"rm", id})
+	}
+
+	args = []string{
+		"volume", "ls", "--quiet",
+		"--filter", "dangling=true",
+		"--filter", labelFilter,
+	}
+	output, _ = commandOutput("docker", args)
+	for _, name := range strings.Fields(output) {
+		inspected, err := commandOutput("docker", []string{"volume", "inspect", "--format={{.CreatedAt}}+++{{index .Labels \"" + acceleratedMountConfigLabel + "\"}}", name})
+		fields := strings.Split(inspected, "+++")
+		if err != nil || len(fields) != 2 || !createdBefore(fields[0], olderThan) || !am.ownsHelper(fields[1]) {
+			continue
+		}
+		printInfof("Removing volume %s ...\n", name)
+		executeHiddenCommand("docker", []string{"volume", "rm", name})
+	}
+}
+
+// Whether a sync container or volume with the given configuration
+// label belongs to this project. Helpers created before the label
+// was introduced do not have it, those are pruned as well.
+func (am *acceleratedMount) ownsHelper(configLabel string) bool {
+	return len(configLabel) == 0 || configLabel == am.configPath
+}
+
 func accelerationEnabled() bool {
 	return runtime.GOOS == "darwin" || runtime.GOOS == "windows"
 }
@@ -0,0 +1,261 @@
+package crane
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Label storing the hash of the build context an image was built from
+const buildHashLabel = "com.crane-orchestration.build-hash"
+
+// A pattern of a .dockerignore file
+type ignorePattern struct {
+	regexp    *regexp.Regexp
+	exception bool
+}
+
+// Reads the patterns of the .dockerignore file in the given
+// directory. Returns no patterns if there is no such file.
+func readDockerignore(dir string) []ignorePattern {
+	patterns := []ignorePattern{}
+	file, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if err != nil {
+		return patterns
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, newIgnorePattern(line))
+	}
+	return patterns
+}
+
+// Translates a .dockerignore pattern into a regular expression.
+// Like Docker, `**` matches any number of directories and a
+// pattern matching a directory also matches everything in it.
+func newIgnorePattern(line string) ignorePattern {
+	pattern := ignorePattern{}
+	if strings.HasPrefix(line, "!") {
+		pattern.exception = true
+		line = strings.TrimSpace(line[1:])
+	}
+	line = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(line)), "/")
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(line); i++ {
+		switch ch := line[i]; ch {
+		case '*':
+			if i+1 < len(line) && line[i+1] == '*' {
+				i++
+				if i+1 < len(line) && line[i+1] == '/' {
+					// `**/` also matches no directory at all
+					i++
+					expr.WriteString("(.*/)?")
+				} else {
+					expr.WriteString(".*")
+				}
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(line[i:], ']')
+			if end < 0 {
+				expr.WriteString(regexp.QuoteMeta(line[i:]))
+				i = len(line)
+			} else {
+				class := line[i+1 : i+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				expr.WriteString("[" + class + "]")
+				i += end
+			}
+		case '\\':
+			if i+1 < len(line) {
+				i++
+				expr.WriteString(regexp.QuoteMeta(string(line[i])))
+			}
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	expr.WriteString("(/.*)?$")
+	compiled, err := regexp.Compile(expr.String())
+	if err != nil {
+		panic(StatusError{fmt.Errorf("Invalid .dockerignore pattern `%s`: %v", line, err), 65})
+	}
+	pattern.regexp = compiled
+	return pattern
+}
+
+// The last matching pattern decides whether the
+// given (slash-separated, relative) path is ignored.
+func isIgnored(patterns []ignorePattern, path string) bool {
+	ignored := false
+	for _, pattern := range patterns {
+		if pattern.regexp.MatchString(path) {
+			ignored = !pattern.exception
+		}
+	}
+	return ignored
+}
+
+func hasExceptions(patterns []ignorePattern) bool {
+	for _, pattern := range patterns {
+		if pattern.exception {
+			return true
+		}
+	}
+	return false
+}
+
+// Computes a hash over the files of the build context which are
+// not ignored by .dockerignore, the Dockerfile and the given build
+// arguments, so that a rebuild can be skipped if none changed.
+func buildContextHash(context string, dockerfile string, args []string) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "args:%q\n", args)
+	patterns := readDockerignore(context)
+	skipIgnoredDirs := !hasExceptions(patterns)
+	err := filepath.Walk(context, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(context, path)
+		if err != nil || relativePath == "." {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		if isIgnored(patterns, relativePath) {
+			if info.IsDir() && skipIgnoredDirs {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		fmt.Fprintf(hash, "%s:%s\n", relativePath, info.Mode())
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "->%s\n", target)
+		} else if info.Mode().IsRegular() {
+			return hashFile(hash, path)
+		}
+		return nil
+	})
+	// Docker always sends the Dockerfile, even if it
+	// is ignored or located outside of the context
+	if err == nil {
+		fmt.Fprintf(hash, "dockerfile:\n")
+		err = hashFile(hash, dockerfile)
+	}
+	if err != nil {
+		panic(StatusError{fmt.Errorf("Error when hashing build context %s: %v", context, err), 74})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func hashFile(hash io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(hash, file)
+	return err
+}
+
+// Returns the images the stages of the given Dockerfile are based on,
+// skipping `scratch` and references to earlier stages. Variables are
+// resolved from the ARG instructions preceding the first FROM and
+// the given build arguments. Returns nothing if the file cannot be read.
+func dockerfileBaseImages(dockerfile string, buildArgs []string) []string {
+	content, err := ioutil.ReadFile(dockerfile)
+	if err != nil {
+		return nil
+	}
+	args := make(map[string]string)
+	overrides := make(map[string]string)
+	for _, buildArg := range buildArgs {
+		parts := strings.SplitN(buildArg, "=", 2)
+		if len(parts) == 2 {
+			overrides[parts[0]] = parts[1]
+		}
+	}
+	var images []string
+	stages := make(map[string]bool)
+	seenFrom := false
+	// Join continued lines first
+	instructions := strings.Replace(string(content), "\\\n", "", -1)
+	for _, line := range strings.Split(instructions, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			if seenFrom {
+				continue
+			}
+			parts := strings.SplitN(fields[1], "=", 2)
+			if value, ok := overrides[parts[0]]; ok {
+				args[parts[0]] = value
+			} else if len(parts) == 2 {
+				args[parts[0]] = strings.Trim(parts[1], `"'`)
+			}
+		case "FROM":
+			seenFrom = true
+			fields = fields[1:]
+			for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+				fields = fields[1:]
+			}
+			if len(fields) == 0 {
+				continue
+			}
+			image := os.Expand(fields[0], func(name string) string {
+				return args[name]
+			})
+			isStage := stages[strings.ToLower(image)]
+			if len(fields) >= 3 && strings.EqualFold(fields[1], "as") {
+				stages[strings.ToLower(fields[2])] = true
+			}
+			if image != "scratch" && !isStage && !includes(images, image) {
+				images = append(images, image)
+			}
+		}
+	}
+	return images
+}
+
+// Normalizes an image reference so that e.g. `alpine`,
+// `alpine:latest` and `docker.io/library/alpine` are equal.
+func normalizeImageName(image string) string {
+	image = strings.TrimPrefix(image, "docker.io/")
+	image = strings.TrimPrefix(image, "library/")
+	if strings.Contains(image, "@") {
+		return image
+	}
+	name := image
+	if slash := strings.LastIndex(image, "/"); slash != -1 {
+		name = image[slash+1:]
+	}
+	if !strings.Contains(name, ":") {
+		image += ":latest"
+	}
+	return image
+}
@@ -0,0 +1,90 @@
+package crane
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsIgnored(t *testing.T) {
+	patterns := []ignorePattern{
+		newIgnorePattern("node_modules"),
+		newIgnorePattern("*.log"),
+		newIgnorePattern("**/*.tmp"),
+		newIgnorePattern("docs/?.md"),
+		newIgnorePattern("!important.log"),
+	}
+	assert.True(t, isIgnored(patterns, "node_modules"))
+	assert.True(t, isIgnored(patterns, "node_modules/foo/index.js"))
+	assert.False(t, isIgnored(patterns, "src/node_modules"))
+	assert.True(t, isIgnored(patterns, "debug.log"))
+	assert.False(t, isIgnored(patterns, "logs/debug.log"))
+	assert.False(t, isIgnored(patterns, "important.log"))
+	assert.True(t, isIgnored(patterns, "a.tmp"))
+	assert.True(t, isIgnored(patterns, "a/b/c.tmp"))
+	assert.True(t, isIgnored(patterns, "docs/a.md"))
+	assert.False(t, isIgnored(patterns, "docs/ab.md"))
+	assert.False(t, isIgnored(patterns, "main.go"))
+}
+
+func TestBuildContextHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crane-build-context")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	write("Dockerfile", "FROM alpine")
+	write(".dockerignore", "tmp\nDockerfile")
+	write("src/main.go", "package main")
+	dockerfile := filepath.Join(dir, "Dockerfile")
+
+	hash := buildContextHash(dir, dockerfile, []string{"build"})
+	assert.Equal(t, hash, buildContextHash(dir, dockerfile, []string{"build"}))
+
+	write("tmp/cache", "ignored")
+	assert.Equal(t, hash, buildContextHash(dir, dockerfile, []string{"build"}), "Ignored files should not change the hash")
+
+	assert.NotEqual(t, hash, buildContextHash(dir, dockerfile, []string{"build", "--build-arg", "a=b"}), "Build arguments should change the hash")
+
+	write("Dockerfile", "FROM busybox")
+	changedDockerfile := buildContextHash(dir, dockerfile, []string{"build"})
+	assert.NotEqual(t, hash, changedDockerfile, "The Dockerfile should change the hash, even if ignored")
+
+	write("src/main.go", "package main\n")
+	assert.NotEqual(t, changedDockerfile, buildContextHash(dir, dockerfile, []string{"build"}), "Files should change the hash")
+}
+
+func TestDockerfileBaseImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crane-dockerfile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dockerfile := filepath.Join(dir, "Dockerfile")
+	content := `ARG BASE=base:1
+ARG VERSION
+FROM --platform=$BUILDPLATFORM golang:${VERSION} AS builder
+RUN go build
+FROM scratch AS empty
+from \
+  $BASE
+COPY --from=builder /app /app
+FROM builder
+`
+	assert.NoError(t, ioutil.WriteFile(dockerfile, []byte(content), 0644))
+	assert.Equal(t, []string{"golang:", "base:1"}, dockerfileBaseImages(dockerfile, nil))
+	assert.Equal(t, []string{"golang:1.12", "base:2"}, dockerfileBaseImages(dockerfile, []string{"VERSION=1.12", "BASE=base:2"}))
+	assert.Nil(t, dockerfileBaseImages(filepath.Join(dir, "missing"), nil))
+}
+
+func TestNormalizeImageName(t *testing.T) {
+	assert.Equal(t, "alpine:latest", normalizeImageName("alpine"))
+	assert.Equal(t, "alpine:latest", normalizeImageName("docker.io/library/alpine"))
+	assert.Equal(t, "alpine:3.9", normalizeImageName("alpine:3.9"))
+	assert.Equal(t, "localhost:5000/app:latest", normalizeImageName("localhost:5000/app"))
+	assert.Equal(t, "app@sha256:abc", normalizeImageName("app@sha256:abc"))
+}
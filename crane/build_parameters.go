@@ -1,11 +1,38 @@
 package crane
 
+import "fmt"
+
+const (
+	buildBackendDocker = "docker"
+	buildBackendBuildx = "buildx"
+)
+
 type BuildParameters struct {
 	RawContext    string      `json:"context" yaml:"context"`
 	RawFile       string      `json:"file" yaml:"file"`
 	RawDockerfile string      `json:"dockerfile" yaml:"dockerfile"`
 	RawBuildArgs  interface{} `json:"build-arg" yaml:"build-arg"`
 	RawArgs       interface{} `json:"args" yaml:"args"`
+	RawTarget     string      `json:"target" yaml:"target"`
+	RawCacheFrom  []string    `json:"cache-from" yaml:"cache-from"`
+	RawCache_From []string    `json:"cache_from" yaml:"cache_from"`
+	RawLabel      interface{} `json:"label" yaml:"label"`
+	RawLabels     interface{} `json:"labels" yaml:"labels"`
+	RawNetwork    string      `json:"network" yaml:"network"`
+	RawShmSize    string      `json:"shm-size" yaml:"shm-size"`
+	RawShm_Size   string      `json:"shm_size" yaml:"shm_size"`
+	RawAddHost    []string    `json:"add-host" yaml:"add-host"`
+	RawExtraHosts []string    `json:"extra_hosts" yaml:"extra_hosts"`
+	Pull          bool        `json:"pull" yaml:"pull"`
+	RawSSH        []string    `json:"ssh" yaml:"ssh"`
+	RawSecret     []string    `json:"secret" yaml:"secret"`
+	RawSecrets    []string    `json:"secrets" yaml:"secrets"`
+	RawBackend    string      `json:"backend" yaml:"backend"`
+	RawPlatforms  []string    `json:"platforms" yaml:"platforms"`
+	RawBuilder    string      `json:"builder" yaml:"builder"`
+	Push          bool        `json:"push" yaml:"push"`
+	InlineCache   bool        `json:"inline-cache" yaml:"inline-cache"`
+	RawDependsOn  []string    `json:"depends_on" yaml:"depends_on"`
 }
 
 func (b BuildParameters) Context() string {
@@ -26,3 +53,104 @@ func (b BuildParameters) BuildArgs() []string {
 	}
 	return buildArgs
 }
+
+func (b BuildParameters) Target() string {
+	return expandEnv(b.RawTarget)
+}
+
+func (b BuildParameters) CacheFrom() []string {
+	rawCacheFrom := b.RawCache_From
+	if len(b.RawCacheFrom) > 0 {
+		rawCacheFrom = b.RawCacheFrom
+	}
+	return expandEach(rawCacheFrom)
+}
+
+func (b BuildParameters) Label() []string {
+	label := sliceOrMap2ExpandedSlice(b.RawLabel)
+	if len(label) == 0 {
+		return sliceOrMap2ExpandedSlice(b.RawLabels)
+	}
+	return label
+}
+
+func (b BuildParameters) Network() string {
+	return expandEnv(b.RawNetwork)
+}
+
+func (b BuildParameters) ShmSize() string {
+	if len(b.RawShmSize) > 0 {
+		return expandEnv(b.RawShmSize)
+	}
+	return expandEnv(b.RawShm_Size)
+}
+
+func (b BuildParameters) AddHost() []string {
+	rawAddHost := b.RawExtraHosts
+	if len(b.RawAddHost) > 0 {
+		rawAddHost = b.RawAddHost
+	}
+	return expandEach(rawAddHost)
+}
+
+func (b BuildParameters) SSH() []string {
+	return expandEach(b.RawSSH)
+}
+
+func (b BuildParameters) Secret() []string {
+	rawSecret := b.RawSecrets
+	if len(b.RawSecret) > 0 {
+		rawSecret = b.RawSecret
+	}
+	return expandEach(rawSecret)
+}
+
+// SSH agent forwarding and secrets are only supported by BuildKit
+func (b BuildParameters) RequiresBuildKit() bool {
+	return len(b.SSH()) > 0 || len(b.Secret()) > 0
+}
+
+// Images are built with `docker build` unless
+// `docker buildx build` is configured.
+func (b BuildParameters) Backend() string {
+	backend := expandEnv(b.RawBackend)
+	switch backend {
+	case "":
+		return buildBackendDocker
+	case buildBackendDocker, buildBackendBuildx:
+		return backend
+	default:
+		panic(StatusError{fmt.Errorf("Build backend must be either `docker` or `buildx`, got `%s`", backend), 65})
+	}
+}
+
+func (b BuildParameters) Platforms() []string {
+	return expandEach(b.RawPlatforms)
+}
+
+// The builder given via --builder takes precedence
+// over the one configured. Builders are a buildx concept,
+// so there is none for the `docker` backend.
+func (b BuildParameters) Builder() string {
+	if b.Backend() != buildBackendBuildx {
+		return ""
+	}
+	if builderFlag != nil && len(*builderFlag) > 0 {
+		return *builderFlag
+	}
+	return expandEnv(b.RawBuilder)
+}
+
+// Services whose images have to be built before this one,
+// in addition to those referenced via FROM in the Dockerfile.
+func (b BuildParameters) DependsOn() []string {
+	return expandEach(b.RawDependsOn)
+}
+
+func expandEach(rawValues []string) []string {
+	var values []string
+	for _, raw := range rawValues {
+		values = append(values, expandEnv(raw))
+	}
+	return values
+}
@@ -3,6 +3,7 @@ package crane
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -42,6 +43,14 @@ var (
 		"tag",
 		"Override image tags.",
 	).String()
+	builderFlag = app.Flag(
+		"builder",
+		"Override the builder of buildx builds, ignored by other builds.",
+	).String()
+	lockedFlag = app.Flag(
+		"locked",
+		"Fail if an image to be pulled is not pinned in crane.lock.",
+	).Bool()
 
 	upCommand = app.Command(
 		"up",
@@ -51,6 +60,10 @@ var (
 		"no-cache",
 		"Build the image(s) without any cache.",
 	).Short('n').Bool()
+	upForceFlag = upCommand.Flag(
+		"force",
+		"Build the image(s) even if their build context did not change.",
+	).Bool()
 	upParallelFlag = upCommand.Flag(
 		"parallel",
 		"Define how many containers are provisioned in parallel.",
@@ -70,6 +83,10 @@ var (
 		"no-cache",
 		"Build the image(s) without any cache.",
 	).Short('n').Bool()
+	liftForceFlag = liftCommand.Flag(
+		"force",
+		"Build the image(s) even if their build context did not change.",
+	).Bool()
 	liftParallelFlag = liftCommand.Flag(
 		"parallel",
 		"Define how many containers are provisioned in parallel.",
@@ -81,6 +98,25 @@ var (
 	liftTargetArg = liftCommand.Arg("target", "Target of command").String()
 	liftCmdArg    = liftCommand.Arg("cmd", "Command for container").Strings()
 
+	testCommand = app.Command(
+		"test",
+		"Run the targeted container attached, then stop and remove all associated containers and networks, as well as the volumes created for it. Exits with the status of the targeted container.",
+	)
+	testNoCacheFlag = testCommand.Flag(
+		"no-cache",
+		"Build the image(s) without any cache.",
+	).Short('n').Bool()
+	testForceFlag = testCommand.Flag(
+		"force",
+		"Build the image(s) even if their build context did not change.",
+	).Bool()
+	testParallelFlag = testCommand.Flag(
+		"parallel",
+		"Define how many containers are provisioned in parallel.",
+	).Short('l').Default("1").Int()
+	testTargetArg = testCommand.Arg("target", "Target of command").Required().String()
+	testCmdArg    = testCommand.Arg("cmd", "Command for container").Strings()
+
 	runCommand = app.Command(
 		"run",
 		"Run containers. Already existing containers will be removed first.",
@@ -109,8 +145,44 @@ var (
 		"stop",
 		"Stop running containers.",
 	)
+	stopTimeFlag = stopCommand.Flag(
+		"time",
+		"Seconds to wait for stop before killing the container. Defaults to the configured stop timeout.",
+	).Short('t').String()
 	stopTargetArg = stopCommand.Arg("target", "Target of command").String()
 
+	restartCommand = app.Command(
+		"restart",
+		"Restart running containers. Stops them in reverse order, then starts them again.",
+	)
+	restartTimeFlag = restartCommand.Flag(
+		"time",
+		"Seconds to wait for stop before killing the container. Defaults to the configured stop timeout.",
+	).Short('t').String()
+	restartTimeoutFlag = restartCommand.Flag(
+		"timeout",
+		"Alias of --time.",
+	).Hidden().String()
+	restartCascadeFlag = restartCommand.Flag(
+		"cascade",
+		"Restart containers depending on the target as well.",
+	).Bool()
+	restartTargetArg = restartCommand.Arg("target", "Target of command").String()
+
+	waitCommand = app.Command(
+		"wait",
+		"Wait until containers are running, healthy or exited. When waiting for containers to exit, their exit code is returned.",
+	)
+	waitForFlag = waitCommand.Flag(
+		"for",
+		"State to wait for (running, healthy or exited).",
+	).Default("running").Enum("running", "healthy", "exited")
+	waitTimeoutFlag = waitCommand.Flag(
+		"timeout",
+		"Give up after the given duration (e.g. 2m). Waits forever by default.",
+	).Duration()
+	waitTargetArg = waitCommand.Arg("target", "Target of command").String()
+
 	killCommand = app.Command(
 		"kill",
 		"Kill running containers.",
@@ -144,8 +216,26 @@ var (
 		"volumes",
 		"Remove volumes as well.",
 	).Bool()
+	rmTimeFlag = rmCommand.Flag(
+		"time",
+		"Seconds to wait for stop before killing a running container (with --force). Defaults to the configured stop timeout.",
+	).Short('t').String()
 	rmTargetArg = rmCommand.Arg("target", "Target of command").String()
 
+	pruneCommand = app.Command(
+		"prune",
+		"Remove stopped ad-hoc containers, as well as unused sync containers and volumes of their accelerated mounts.",
+	)
+	pruneAdHocFlag = pruneCommand.Flag(
+		"ad-hoc",
+		"Remove ad-hoc containers only.",
+	).Bool()
+	pruneOlderThanFlag = pruneCommand.Flag(
+		"older-than",
+		"Remove only what was created longer ago than the given duration (e.g. 24h).",
+	).Duration()
+	pruneTargetArg = pruneCommand.Arg("target", "Target of command").String()
+
 	pauseCommand = app.Command(
 		"pause",
 		"Pause running containers.",
@@ -166,6 +256,10 @@ var (
 		"no-cache",
 		"Build the image(s) without any cache.",
 	).Short('n').Bool()
+	provisionForceFlag = provisionCommand.Flag(
+		"force",
+		"Build the image(s) even if their build context did not change.",
+	).Bool()
 	provisionParallelFlag = provisionCommand.Flag(
 		"parallel",
 		"Define how many containers are provisioned in parallel.",
@@ -176,12 +270,38 @@ var (
 		"pull",
 		"Pull images.",
 	)
+	pullMissingFlag = pullCommand.Flag(
+		"missing",
+		"Only pull images which are not present locally.",
+	).Bool()
+	pullRetriesFlag = pullCommand.Flag(
+		"retries",
+		"Define how often pulling an image is retried on timeouts and connection failures.",
+	).Default("2").Int()
+	pullParallelFlag = pullCommand.Flag(
+		"parallel",
+		"Define how many images are pulled in parallel.",
+	).Short('l').Default("1").Int()
 	pullTargetArg = pullCommand.Arg("target", "Target of command").String()
 
+	lockCommand = app.Command(
+		"lock",
+		"Pull images and pin them to their digests in crane.lock, which is used by all other commands afterwards.",
+	)
+	lockTargetArg = lockCommand.Arg("target", "Target of command").String()
+
 	pushCommand = app.Command(
 		"push",
 		"Push containers to the registry.",
 	)
+	pushToFlag = pushCommand.Flag(
+		"to",
+		"Additional image reference to tag and push (repeatable). Supports {{service}}, {{git.sha}}, {{git.short_sha}} and {{git.branch}}.",
+	).PlaceHolder("REFERENCE").Strings()
+	pushParallelFlag = pushCommand.Flag(
+		"parallel",
+		"Define how many services are pushed in parallel.",
+	).Short('l').Default("1").Int()
 	pushTargetArg = pushCommand.Arg("target", "Target of command").String()
 
 	logsCommand = app.Command(
@@ -190,7 +310,7 @@ var (
 	)
 	followFlag = logsCommand.Flag(
 		"follow",
-		"Follow log output.",
+		"Follow log output, also across restarts and recreation of containers.",
 	).Short('f').Bool()
 	tailFlag = logsCommand.Flag(
 		"tail",
@@ -208,6 +328,30 @@ var (
 		"since",
 		"Show logs since timestamp.",
 	).String()
+	untilFlag = logsCommand.Flag(
+		"until",
+		"Show logs before timestamp.",
+	).String()
+	grepFlag = logsCommand.Flag(
+		"grep",
+		"Only show lines matching the given regular expression.",
+	).PlaceHolder("REGEX").String()
+	invertFlag = logsCommand.Flag(
+		"invert",
+		"Only show lines not matching the --grep expression.",
+	).Bool()
+	levelFlag = logsCommand.Flag(
+		"level",
+		"Only show lines of the given log level or above (trace, debug, info, warn, error or fatal), as found in JSON, logfmt or prefixed lines.",
+	).Enum(logLevels...)
+	logsFormatFlag = logsCommand.Flag(
+		"format",
+		"Output format, either text or json (one object per line).",
+	).Default(logFormatText).Enum(logFormatText, logFormatJSON)
+	logsOutputDirFlag = logsCommand.Flag(
+		"output-dir",
+		"Write the logs of each container to its own file in the given directory.",
+	).PlaceHolder("DIR").String()
 	logsTargetArg = logsCommand.Arg("target", "Target of command").String()
 
 	statsCommand = app.Command(
@@ -230,6 +374,34 @@ var (
 	).Short('n').Bool()
 	statusTargetArg = statusCommand.Arg("target", "Target of command").String()
 
+	imagesCommand = app.Command(
+		"images",
+		"List the images of the services, with their size and whether they are in use.",
+	)
+	imagesNoTruncFlag = imagesCommand.Flag(
+		"no-trunc",
+		"Don't truncate output.",
+	).Short('n').Bool()
+	imagesTargetArg = imagesCommand.Arg("target", "Target of command").String()
+
+	rmiCommand = app.Command(
+		"rmi",
+		"Remove the images built for the services, as well as dangling images left behind by earlier builds.",
+	)
+	rmiDanglingOnlyFlag = rmiCommand.Flag(
+		"dangling-only",
+		"Only remove dangling images left behind by earlier builds.",
+	).Bool()
+	rmiUnusedFlag = rmiCommand.Flag(
+		"unused",
+		"Skip images in use by containers instead of failing.",
+	).Bool()
+	rmiPulledFlag = rmiCommand.Flag(
+		"pulled",
+		"Remove pulled images as well, not only those built.",
+	).Bool()
+	rmiTargetArg = rmiCommand.Arg("target", "Target of command").String()
+
 	cmdCommand = app.Command(
 		"cmd",
 		"Execute predefined shortcut command.",
@@ -270,6 +442,35 @@ var (
 	).Short('f').Bool()
 	amLogsTargetArg = amLogsCommand.Arg("target", "Target of command").String()
 
+	volumeCommand = app.Command(
+		"volume",
+		"Sub-commands for volumes.",
+	)
+	volumeBackupCommand = volumeCommand.Command(
+		"backup",
+		"Back up the content of a volume into a gzipped tarball.",
+	)
+	volumeBackupOutputFlag = volumeBackupCommand.Flag(
+		"output",
+		"The file to write the backup to.",
+	).Short('O').Required().PlaceHolder("file.tar.gz").String()
+	volumeBackupVolumeArg = volumeBackupCommand.Arg("volume", "Volume to back up").Required().String()
+	volumeRestoreCommand  = volumeCommand.Command(
+		"restore",
+		"Replace the content of a volume with a backup.",
+	)
+	volumeRestoreInputFlag = volumeRestoreCommand.Flag(
+		"input",
+		"The backup to restore.",
+	).Short('i').Required().PlaceHolder("file.tar.gz").String()
+	volumeRestoreVolumeArg = volumeRestoreCommand.Arg("volume", "Volume to restore").Required().String()
+	volumeCloneCommand     = volumeCommand.Command(
+		"clone",
+		"Replace the content of a volume with the content of another volume.",
+	)
+	volumeCloneSourceArg      = volumeCloneCommand.Arg("source", "Volume to copy from").Required().String()
+	volumeCloneDestinationArg = volumeCloneCommand.Arg("destination", "Volume to copy to").Required().String()
+
 	versionCommand = app.Command(
 		"version",
 		"Display the current version.",
@@ -285,6 +486,12 @@ func isDryRun() bool {
 }
 
 func commandAction(targetArg string, wrapped func(unitOfWork *UnitOfWork), mightStartRelated bool) {
+	cascadingCommandAction(targetArg, false, wrapped, mightStartRelated)
+}
+
+// Like commandAction, but extends the target to all containers
+// depending on it if cascade is true.
+func cascadingCommandAction(targetArg string, cascade bool, wrapped func(unitOfWork *UnitOfWork), mightStartRelated bool) {
 
 	cfg = NewConfig(*configFlag, *prefixFlag, *tagFlag)
 	allowed = allowedContainers(*excludeFlag, *onlyFlag)
@@ -293,6 +500,9 @@ func commandAction(targetArg string, wrapped func(unitOfWork *UnitOfWork), might
 	if err != nil {
 		panic(StatusError{err, 78})
 	}
+	if cascade {
+		target.extendToDependents(dependencyMap)
+	}
 	unitOfWork, err := NewUnitOfWork(dependencyMap, target.all())
 	if err != nil {
 		panic(StatusError{err, 78})
@@ -319,6 +529,40 @@ func commandAction(targetArg string, wrapped func(unitOfWork *UnitOfWork), might
 	wrapped(unitOfWork)
 }
 
+// Resolves the given volumes, and runs the wrapped function while the
+// containers using them are stopped. Volumes which are not configured
+// are referred to by their actual name.
+func volumeAction(names []string, wrapped func(volumes []Volume)) {
+	cfg = NewConfig(*configFlag, *prefixFlag, *tagFlag)
+	allowed = allowedContainers(*excludeFlag, *onlyFlag)
+	volumes := []Volume{}
+	users := []string{}
+	for _, name := range names {
+		v := cfg.Volume(name)
+		if v == nil {
+			v = &volume{RawName: name, RawActualName: name}
+		}
+		volumes = append(volumes, v)
+		for _, containerName := range allowed {
+			if includes(cfg.Container(containerName).VolumeSources(), name) && !includes(users, containerName) {
+				users = append(users, containerName)
+			}
+		}
+	}
+	if len(users) == 0 {
+		wrapped(volumes)
+		return
+	}
+	sort.Strings(users)
+	unitOfWork, err := NewUnitOfWork(cfg.DependencyMap(), users)
+	if err != nil {
+		panic(StatusError{err, 78})
+	}
+	unitOfWork.WhileStopped(func() {
+		wrapped(volumes)
+	})
+}
+
 func allowedContainers(excludedReference []string, onlyReference string) (containers []string) {
 	allContainers := []string{}
 	if len(onlyReference) == 0 {
@@ -390,12 +634,12 @@ func runCli() {
 
 	case upCommand.FullCommand():
 		commandAction(*upTargetArg, func(uow *UnitOfWork) {
-			uow.Up(*upCmdArg, *upDetachFlag, *upNoCacheFlag, *upParallelFlag)
+			uow.Up(*upCmdArg, *upDetachFlag, *upNoCacheFlag, *upForceFlag, *upParallelFlag)
 		}, true)
 
 	case liftCommand.FullCommand():
 		commandAction(*liftTargetArg, func(uow *UnitOfWork) {
-			uow.Up(*liftCmdArg, *liftDetachFlag, *liftNoCacheFlag, *liftParallelFlag)
+			uow.Up(*liftCmdArg, *liftDetachFlag, *liftNoCacheFlag, *liftForceFlag, *liftParallelFlag)
 		}, true)
 
 	case versionCommand.FullCommand():
@@ -411,9 +655,19 @@ func runCli() {
 			uow.Status(*noTruncFlag)
 		}, false)
 
+	case imagesCommand.FullCommand():
+		commandAction(*imagesTargetArg, func(uow *UnitOfWork) {
+			uow.Images(*imagesNoTruncFlag)
+		}, false)
+
+	case rmiCommand.FullCommand():
+		commandAction(*rmiTargetArg, func(uow *UnitOfWork) {
+			uow.RemoveImages(*rmiDanglingOnlyFlag, *rmiUnusedFlag, *rmiPulledFlag)
+		}, false)
+
 	case pushCommand.FullCommand():
 		commandAction(*pushTargetArg, func(uow *UnitOfWork) {
-			uow.Push()
+			uow.Push(*pushToFlag, *pushParallelFlag)
 		}, false)
 
 	case unpauseCommand.FullCommand():
@@ -433,7 +687,21 @@ func runCli() {
 
 	case stopCommand.FullCommand():
 		commandAction(*stopTargetArg, func(uow *UnitOfWork) {
-			uow.Stop()
+			uow.Stop(*stopTimeFlag)
+		}, false)
+
+	case restartCommand.FullCommand():
+		cascadingCommandAction(*restartTargetArg, *restartCascadeFlag, func(uow *UnitOfWork) {
+			timeout := *restartTimeFlag
+			if len(timeout) == 0 {
+				timeout = *restartTimeoutFlag
+			}
+			uow.Restart(timeout)
+		}, true)
+
+	case waitCommand.FullCommand():
+		commandAction(*waitTargetArg, func(uow *UnitOfWork) {
+			uow.Wait(*waitForFlag, *waitTimeoutFlag)
 		}, false)
 
 	case killCommand.FullCommand():
@@ -448,7 +716,17 @@ func runCli() {
 
 	case rmCommand.FullCommand():
 		commandAction(*rmTargetArg, func(uow *UnitOfWork) {
-			uow.Rm(*rmForceFlag, *rmVolumesFlag)
+			uow.Rm(*rmForceFlag, *rmVolumesFlag, *rmTimeFlag)
+		}, false)
+
+	case testCommand.FullCommand():
+		commandAction(*testTargetArg, func(uow *UnitOfWork) {
+			uow.Test(*testCmdArg, *testNoCacheFlag, *testForceFlag, *testParallelFlag)
+		}, true)
+
+	case pruneCommand.FullCommand():
+		commandAction(*pruneTargetArg, func(uow *UnitOfWork) {
+			uow.Prune(*pruneAdHocFlag, *pruneOlderThanFlag)
 		}, false)
 
 	case runCommand.FullCommand():
@@ -463,17 +741,23 @@ func runCli() {
 
 	case provisionCommand.FullCommand():
 		commandAction(*provisionTargetArg, func(uow *UnitOfWork) {
-			uow.Provision(*provisionNoCacheFlag, *provisionParallelFlag)
+			uow.Provision(*provisionNoCacheFlag, *provisionForceFlag, *provisionParallelFlag)
 		}, false)
 
 	case pullCommand.FullCommand():
 		commandAction(*pullTargetArg, func(uow *UnitOfWork) {
-			uow.PullImage()
+			uow.PullImage(*pullMissingFlag, *pullRetriesFlag, *pullParallelFlag)
+		}, false)
+
+	case lockCommand.FullCommand():
+		commandAction(*lockTargetArg, func(uow *UnitOfWork) {
+			uow.Lock()
 		}, false)
 
 	case logsCommand.FullCommand():
+		filter := newLogFilter(*grepFlag, *invertFlag, *levelFlag)
 		commandAction(*logsTargetArg, func(uow *UnitOfWork) {
-			uow.Logs(*followFlag, *timestampsFlag, *tailFlag, *colorizeFlag, *sinceFlag)
+			uow.Logs(*followFlag, *timestampsFlag, *tailFlag, *colorizeFlag, *sinceFlag, *untilFlag, filter, *logsFormatFlag, *logsOutputDirFlag)
 		}, false)
 
 	case generateCommand.FullCommand():
@@ -485,6 +769,34 @@ func runCli() {
 			uow.Generate(*templateFlag, *outputFlag)
 		}, false)
 
+	case volumeBackupCommand.FullCommand():
+		// Fail before stopping any containers
+		if _, err := os.Stat(filepath.Dir(*volumeBackupOutputFlag)); err != nil {
+			panic(StatusError{err, 73})
+		}
+		volumeAction([]string{*volumeBackupVolumeArg}, func(volumes []Volume) {
+			volumes[0].Backup(*volumeBackupOutputFlag)
+		})
+
+	case volumeRestoreCommand.FullCommand():
+		if _, err := os.Stat(*volumeRestoreInputFlag); err != nil {
+			panic(StatusError{err, 66})
+		}
+		volumeAction([]string{*volumeRestoreVolumeArg}, func(volumes []Volume) {
+			if !volumes[0].Exists() {
+				volumes[0].Create()
+			}
+			volumes[0].Restore(*volumeRestoreInputFlag)
+		})
+
+	case volumeCloneCommand.FullCommand():
+		volumeAction([]string{*volumeCloneSourceArg, *volumeCloneDestinationArg}, func(volumes []Volume) {
+			if !volumes[1].Exists() {
+				volumes[1].Create()
+			}
+			volumes[0].CloneTo(volumes[1])
+		})
+
 	case amResetCommand.FullCommand():
 		cfg = NewConfig(*configFlag, *prefixFlag, *tagFlag)
 		resetTargets := []string{}
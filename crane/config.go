@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
@@ -24,6 +25,7 @@ type Config interface {
 	UniqueID() string
 	Prefix() string
 	Tag() string
+	LockedImage(image string) (string, bool)
 	NetworkNames() []string
 	VolumeNames() []string
 	Cmds() map[string][]string
@@ -32,6 +34,7 @@ type Config interface {
 	Volume(name string) Volume
 	Cmd(name string) []string
 	AcceleratedMount(volume string) AcceleratedMount
+	ProjectHooks() ProjectHooks
 	ContainerMap() ContainerMap
 	Container(name string) Container
 	ContainerInfo(name string) ContainerInfo
@@ -42,6 +45,7 @@ type config struct {
 	RawContainers        map[string]*container        `json:"services" yaml:"services"`
 	RawGroups            map[string][]string          `json:"groups" yaml:"groups"`
 	RawHooks             map[string]hooks             `json:"hooks" yaml:"hooks"`
+	RawProjectHooks      projectHooks                 `json:"project-hooks" yaml:"project-hooks"`
 	RawNetworks          map[string]*network          `json:"networks" yaml:"networks"`
 	RawVolumes           map[string]*volume           `json:"volumes" yaml:"volumes"`
 	RawCmds              map[string]interface{}       `json:"commands" yaml:"commands"`
@@ -56,6 +60,7 @@ type config struct {
 	path                 string
 	prefix               string
 	tag                  string
+	lock                 *lockFile
 	uniqueID             string
 }
 
@@ -142,6 +147,7 @@ func NewConfig(files []string, prefix string, tag string) Config {
 	config.initialize(prefix)
 	config.validate()
 	config.tag = tag
+	config.lock = readLockFile(configPath)
 	milliseconds := time.Now().UnixNano() / 1000000
 	config.uniqueID = strconv.FormatInt(milliseconds, 10)
 	return config
@@ -214,6 +220,16 @@ func (c *config) Tag() string {
 	return c.tag
 }
 
+// Returns the digest reference the given image is pinned to in
+// the lockfile. Not ok if there is no lockfile or no such entry.
+func (c *config) LockedImage(image string) (string, bool) {
+	if c.lock == nil {
+		return "", false
+	}
+	locked, ok := c.lock.Images[image]
+	return locked, ok
+}
+
 func (c *config) ContainerMap() ContainerMap {
 	return c.containerMap
 }
@@ -269,6 +285,10 @@ func (c *config) AcceleratedMount(name string) AcceleratedMount {
 	return c.acceleratedMountMap[name]
 }
 
+func (c *config) ProjectHooks() ProjectHooks {
+	return &c.RawProjectHooks
+}
+
 func (c *config) Cmd(name string) []string {
 	return c.cmds[name]
 }
@@ -425,10 +445,23 @@ func (c *config) determinePrefix(prefixFlag string) {
 
 func (c *config) validate() {
 	for name, container := range c.RawContainers {
-		if len(container.RawImage) == 0 && container.RawBuild == (BuildParameters{}) {
+		if len(container.RawImage) == 0 && reflect.DeepEqual(container.RawBuild, BuildParameters{}) {
 			panic(StatusError{fmt.Errorf("Neither image or build specified for `%s`", name), 64})
 		}
+		for _, dependency := range container.RawBuild.DependsOn() {
+			if _, ok := c.RawContainers[dependency]; !ok {
+				panic(StatusError{fmt.Errorf("Build of `%s` depends on unknown service `%s`", name, dependency), 65})
+			}
+		}
+		// Images built for several platforms cannot be loaded into Docker
+		if container.RawBuild.Backend() == buildBackendBuildx && len(container.RawBuild.Platforms()) > 1 && !container.RawBuild.Push {
+			panic(StatusError{fmt.Errorf("Build of `%s` is for more than one platform and must therefore set `push: true`", name), 65})
+		}
+	}
+	for name, hooks := range c.RawHooks {
+		hooks.validate(expandEnv(name))
 	}
+	c.RawProjectHooks.validate()
 }
 
 // DependencyMap returns a map of containers to their dependencies.
@@ -236,6 +236,18 @@ volumes:
 	assert.Equal(t, "bar", config.volumeMap["bar"].Name())
 }
 
+func TestUnmarshalProjectHooks(t *testing.T) {
+	yaml := []byte(
+		`project-hooks:
+  before-up: echo before
+  after-down: echo after
+`)
+	config := unmarshal(yaml, ".yml")
+	assert.Equal(t, "echo before", config.ProjectHooks().BeforeUp()[0].Exec())
+	assert.Empty(t, config.ProjectHooks().AfterUp())
+	assert.Equal(t, "echo after", config.ProjectHooks().AfterDown()[0].Exec())
+}
+
 func TestInitialize(t *testing.T) {
 	// use different, undefined environment variables throughout the config to detect any issue in expansion
 	rawContainerMap := map[string]*container{
@@ -266,10 +278,10 @@ func TestInitialize(t *testing.T) {
 	assert.Equal(t, "a", c.containerMap["a"].Name())
 	assert.Equal(t, "b", c.containerMap["b"].Name())
 	assert.Equal(t, map[string][]string{"default": []string{"a", "b"}}, c.groups)
-	assert.Equal(t, "custom-pre-start", c.containerMap["a"].Hooks().PreStart(), "Container should have a custom pre-start hook overriding the default one")
-	assert.Equal(t, "default-post-start", c.containerMap["a"].Hooks().PostStart(), "Container should have a default post-start hook")
-	assert.Equal(t, "default-pre-start", c.containerMap["b"].Hooks().PreStart(), "Container should have a default post-start hook")
-	assert.Equal(t, "default-post-start", c.containerMap["b"].Hooks().PostStart(), "Container should have a default post-start hook")
+	assert.Equal(t, "custom-pre-start", c.containerMap["a"].Hooks().PreStart()[0].Exec(), "Container should have a custom pre-start hook overriding the default one")
+	assert.Equal(t, "default-post-start", c.containerMap["a"].Hooks().PostStart()[0].Exec(), "Container should have a default post-start hook")
+	assert.Equal(t, "default-pre-start", c.containerMap["b"].Hooks().PreStart()[0].Exec(), "Container should have a default post-start hook")
+	assert.Equal(t, "default-post-start", c.containerMap["b"].Hooks().PostStart()[0].Exec(), "Container should have a default post-start hook")
 }
 
 func TestInitializeAmbiguousHooks(t *testing.T) {
@@ -312,6 +324,60 @@ func TestValidate(t *testing.T) {
 	assert.Panics(t, func() {
 		c.validate()
 	})
+	rawContainerMap = map[string]*container{
+		"a": &container{RawName: "a", RawImage: "ubuntu"},
+		"b": &container{RawName: "b", RawBuild: BuildParameters{RawContext: "b", RawDependsOn: []string{"a"}}},
+	}
+	c = &config{RawContainers: rawContainerMap}
+	assert.NotPanics(t, func() {
+		c.validate()
+	})
+	rawContainerMap = map[string]*container{
+		"b": &container{RawName: "b", RawBuild: BuildParameters{RawContext: "b", RawDependsOn: []string{"a"}}},
+	}
+	c = &config{RawContainers: rawContainerMap}
+	assert.Panics(t, func() {
+		c.validate()
+	})
+	// Builds for several platforms have to be pushed
+	multiPlatform := BuildParameters{RawContext: "a", RawBackend: "buildx", RawPlatforms: []string{"linux/amd64", "linux/arm64"}}
+	c = &config{RawContainers: map[string]*container{"a": &container{RawName: "a", RawBuild: multiPlatform}}}
+	assert.Panics(t, func() {
+		c.validate()
+	})
+	multiPlatform.Push = true
+	c = &config{RawContainers: map[string]*container{"a": &container{RawName: "a", RawBuild: multiPlatform}}}
+	assert.NotPanics(t, func() {
+		c.validate()
+	})
+	singlePlatform := BuildParameters{RawContext: "a", RawBackend: "buildx", RawPlatforms: []string{"linux/arm64"}}
+	c = &config{RawContainers: map[string]*container{"a": &container{RawName: "a", RawBuild: singlePlatform}}}
+	assert.NotPanics(t, func() {
+		c.validate()
+	})
+	// Hooks are parsed when validating
+	c = &config{RawHooks: map[string]hooks{
+		"a": hooks{RawPostStart: map[string]interface{}{"exec": "echo foo", "in": "container"}},
+	}}
+	assert.NotPanics(t, func() {
+		c.validate()
+	})
+	c = &config{RawHooks: map[string]hooks{
+		"a": hooks{RawPostStop: map[string]interface{}{"exec": "echo foo", "on-failure": "retry"}},
+	}}
+	assert.Panics(t, func() {
+		c.validate()
+	})
+	c = &config{RawHooks: map[string]hooks{
+		"a": hooks{RawPreStart: map[string]interface{}{"exec": "echo foo", "in": "container"}},
+	}}
+	assert.Panics(t, func() {
+		c.validate()
+	})
+	c = &config{RawProjectHooks: projectHooks{RawAfterUp: map[string]interface{}{"exec": "echo foo", "in": "container"}}}
+	assert.Panics(t, func() {
+		c.validate()
+	})
 }
 
 func TestDependencyMap(t *testing.T) {
@@ -432,8 +498,8 @@ func TestVolumeNames(t *testing.T) {
 	assert.Equal(t, []string{}, volumes)
 
 	volumeMap = map[string]Volume{
-		"foo": &network{},
-		"bar": &network{},
+		"foo": &volume{},
+		"bar": &volume{},
 	}
 	c = &config{volumeMap: volumeMap}
 	volumes = c.VolumeNames()
@@ -2,41 +2,60 @@ package crane
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const netBridge = "bridge"
 
+const (
+	adHocLabel   = "com.crane-orchestration.ad-hoc"
+	createdLabel = "com.crane-orchestration.created"
+	serviceLabel = "com.crane-orchestration.service"
+)
+
 type Container interface {
 	ContainerInfo
 	Exists() bool
 	Running() bool
 	Paused() bool
+	Healthy() bool
+	Exited() bool
+	ExitCode() int
 	Status() [][]string
-	Provision(nocache bool)
-	PullImage()
+	Provision(nocache bool, force bool)
+	PullImage(retries int)
+	ImageExists() bool
+	ImageStatus() []string
+	RemoveImage(danglingOnly bool, unusedOnly bool, pulled bool)
+	LockImage() string
 	Create(cmds []string)
-	Run(cmds []string, targeted bool, detachFlag bool)
+	Run(cmds []string, targeted bool, detachFlag bool, started func())
+	Attaches(cmds []string, detachFlag bool) bool
 	Start(targeted bool)
 	Kill()
-	Stop()
+	Stop(timeout string)
 	Pause()
 	Unpause()
 	Exec(cmds []string, privileged bool, user string)
-	Rm(force bool, volumes bool)
-	Logs(follow bool, since string, tail string) (sources []LogSource)
-	Push()
+	Rm(force bool, volumes bool, timeout string) bool
+	PruneAdHoc(olderThan time.Duration)
+	Logs(follow bool, since string, until string, tail string) (sources []LogSource)
+	Push(references []string) []PushedImage
 	SetCommandsOutput(stdout, stderr io.Writer)
 	CommandsOut() io.Writer
 	CommandsErr() io.Writer
 	BindMounts(volumeNames []string) []string
+	AcceleratedMounts() []AcceleratedMount
 	VolumeSources() []string
 	Net() string
 	Networks() map[string]NetworkParameters
@@ -47,9 +66,12 @@ type ContainerInfo interface {
 	PrefixedName() string
 	ActualName(bool) string
 	Image() string
+	UnlockedImage() string
+	PushReferences() []string
 	ID() string
 	Dependencies() *Dependencies
 	BuildParams() BuildParameters
+	BaseImages() []string
 	Hooks() Hooks
 }
 
@@ -60,6 +82,7 @@ type container struct {
 	RawRequires          []string              `json:"requires" yaml:"requires"`
 	RawDependsOn         []string              `json:"depends_on" yaml:"depends_on"`
 	RawBuild             BuildParameters       `json:"build" yaml:"build"`
+	RawPush              []string              `json:"push" yaml:"push"`
 	RawAddHost           []string              `json:"add-host" yaml:"add-host"`
 	RawExtraHosts        []string              `json:"extra-hosts" yaml:"extra-hosts"`
 	BlkioWeight          int                   `json:"blkio-weight" yaml:"blkio-weight"`
@@ -191,6 +214,15 @@ type LogSource struct {
 	Stdout io.Reader
 	Stderr io.Reader
 	Name   string
+	cmd    *exec.Cmd
+}
+
+// Waits for the command producing the logs, once both
+// streams have been read, so that it does not linger.
+func (s LogSource) wait() {
+	if s.cmd != nil {
+		s.cmd.Wait()
+	}
 }
 
 func (o *OptInt) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -296,7 +328,24 @@ func (c *container) ActualName(adHoc bool) string {
 	return c.PrefixedName()
 }
 
+// Pulled images are pinned to their digest if a lockfile exists.
+// With --locked, images which are not pinned are rejected.
 func (c *container) Image() string {
+	image := c.UnlockedImage()
+	if len(c.BuildParams().Context()) > 0 || strings.Contains(image, "@") {
+		return image
+	}
+	if locked, ok := cfg.LockedImage(image); ok {
+		return locked
+	}
+	if lockedFlag != nil && *lockedFlag {
+		panic(StatusError{fmt.Errorf("Image %s of %s is not pinned in %s, run `crane lock` to update it", image, c.Name(), lockFileName), 65})
+	}
+	return image
+}
+
+// The configured image, with the tag overridden by --tag
+func (c *container) UnlockedImage() string {
 	if len(c.RawImage) == 0 {
 		return c.ActualName(false)
 	}
@@ -787,11 +836,18 @@ func (c *container) StopSignal() string {
 	return expandEnv(c.RawStop_Signal)
 }
 
+// Returns the stop timeout in seconds. As `stop_grace_period`
+// is given as a duration (e.g. "1m30s"), durations are
+// converted to seconds.
 func (c *container) StopTimeout() string {
+	timeout := expandEnv(c.RawStop_Grace_Period)
 	if len(c.RawStopTimeout) > 0 {
-		return expandEnv(c.RawStopTimeout)
+		timeout = expandEnv(c.RawStopTimeout)
 	}
-	return expandEnv(c.RawStop_Grace_Period)
+	if duration, err := time.ParseDuration(timeout); err == nil {
+		return strconv.Itoa(int(duration.Seconds()))
+	}
+	return timeout
 }
 
 func (c *container) Sysctl() []string {
@@ -916,11 +972,11 @@ func (c *container) Exists() bool {
 func (c *container) Status() [][]string {
 	rows := [][]string{}
 	if !c.Exists() {
-		fields := []string{c.ActualName(false), c.Image(), "-", "-", "-", "-", "-"}
+		fields := []string{c.ActualName(false), c.UnlockedImage(), "-", "-", "-", "-", "-"}
 		rows = append(rows, fields)
 	} else {
 		name := c.ActualName(false)
-		fields := []string{name, c.Image(), "-", "-", "-", "-", "-"}
+		fields := []string{name, c.UnlockedImage(), "-", "-", "-", "-", "-"}
 		// When using a `--tag` global flag, c.Image() may not represent an actual image tag.
 		// Instead we should get an image tag by inspecting "Config.Image".
 		output := inspectString(name, "{{.Config.Image}}+++{{.Id}}+++{{.Image}}+++{{if .NetworkSettings.IPAddress}}{{.NetworkSettings.IPAddress}}{{else}}-{{end}}+++{{range $k,$v := $.NetworkSettings.Ports}}{{$k}},{{else}}-{{end}}+++{{.State.Running}}")
@@ -934,11 +990,13 @@ func (c *container) Status() [][]string {
 	return rows
 }
 
-func (c *container) Provision(nocache bool) {
+// Build or pull the image. Builds are skipped if the image
+// was built from the same context already, unless forced.
+func (c *container) Provision(nocache bool, force bool) {
 	if len(c.BuildParams().Context()) > 0 {
-		c.buildImage(nocache)
+		c.buildImage(nocache, force)
 	} else {
-		c.PullImage()
+		c.PullImage(defaultPullRetries)
 	}
 }
 
@@ -946,7 +1004,7 @@ func (c *container) Provision(nocache bool) {
 func (c *container) Create(cmds []string) {
 	adHoc := (len(cmds) > 0)
 	if !adHoc {
-		c.Rm(true, false)
+		c.rm(true, false, "", false)
 	}
 	msg := "Creating container %s"
 	if adHoc {
@@ -954,20 +1012,24 @@ func (c *container) Create(cmds []string) {
 	}
 	fmt.Fprintf(c.CommandsOut(), msg+" ...\n", c.ActualName(adHoc))
 
+	c.executeHook("pre-create", c.Hooks().PreCreate(), adHoc)
 	args := append([]string{"create"}, c.createArgs(cmds)...)
 	executeCommand("docker", args, c.CommandsOut(), c.CommandsErr())
 
 	c.connectWithNetworks(adHoc)
+	c.executeHook("post-create", c.Hooks().PostCreate(), adHoc)
 }
 
 // Run container (possibly removes existing one)
 // Implemented as create+start as we also need to connect to networks,
 // and that might fail if we used "docker run" and
-// have a very short-lived container.
-func (c *container) Run(cmds []string, targeted bool, detachFlag bool) {
+// have a very short-lived container. If given, `started` is called
+// once the container has been started (and its post-start hook has
+// completed), also while still attached to the container.
+func (c *container) Run(cmds []string, targeted bool, detachFlag bool, started func()) {
 	adHoc := (len(cmds) > 0)
 	if !adHoc {
-		c.Rm(true, false)
+		c.rm(true, false, "", false)
 	}
 	msg := "Running container %s"
 	if adHoc {
@@ -975,14 +1037,33 @@ func (c *container) Run(cmds []string, targeted bool, detachFlag bool) {
 	}
 	fmt.Fprintf(c.CommandsOut(), msg+" ...\n", c.ActualName(adHoc))
 
+	c.executeHook("pre-create", c.Hooks().PreCreate(), adHoc)
 	args := append([]string{"create"}, c.createArgs(cmds)...)
 	// Hide output of container ID, the name of the container
 	// is printed later anyway when it is started.
 	executeCommand("docker", args, nil, c.CommandsErr())
 
 	c.connectWithNetworks(adHoc)
+	c.executeHook("post-create", c.Hooks().PostCreate(), adHoc)
 
-	c.start(adHoc, targeted, detachFlag)
+	if adHoc {
+		// Docker removes ad-hoc containers once they exit, but they
+		// are left behind if Crane is interrupted before.
+		unregister := registerCleanup(c.removeAdHoc)
+		c.start(adHoc, targeted, detachFlag, started)
+		unregister()
+	} else {
+		c.start(adHoc, targeted, detachFlag, started)
+	}
+}
+
+// Remove ad-hoc container if it is still around
+func (c *container) removeAdHoc() {
+	name := c.ActualName(true)
+	if containerID(name) != "" {
+		fmt.Fprintf(c.CommandsOut(), "Removing ad-hoc container %s ...\n", name)
+		executeHiddenCommand("docker", []string{"rm", "--force", name})
+	}
 }
 
 // Connects container with default network if required,
@@ -1010,33 +1091,128 @@ func (c *container) connectWithNetworks(adHoc bool) {
 	}
 }
 
-// FIXME: Output from this (e.g. verbose logging) interferes with
-// container output ...
-func (c *container) executePostStartHook(adHoc bool) *sync.WaitGroup {
-	var wg sync.WaitGroup
+// Runs the post-start hook and then the given callback (if any) once
+// the container has been started. The
+// subscription to the start event is made before the container is
+// started, and replays events since the container last changed its
+// state, so that it cannot be missed.
+// Output of the hook is prefixed to tell it apart from the output of
+// the container. For attached containers, steps may be detached, in
+// which case they are killed once the container exits instead of
+// delaying Crane. The returned function must be called after the
+// start, it waits for the hook and fails if the hook failed.
+func (c *container) executePostStartHook(adHoc bool, attached bool, started func()) (wait func()) {
+	hook := c.Hooks().PostStart()
+	if len(hook) == 0 && started == nil {
+		return func() {}
+	}
+	name := c.ActualName(adHoc)
+	reference := containerID(name)
+	if len(reference) == 0 {
+		reference = name
+	}
+	since := lastStateChange(reference)
+	cmd, cmdOut, _ := executeCommandBackground("docker", []string{
+		"events",
+		"--since", fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond()),
+		"--filter", "event=start",
+		"--filter", "container=" + reference,
+	})
+	if cmd == nil {
+		return func() {
+			if started != nil {
+				started()
+			}
+		}
+	}
 
-	if len(c.Hooks().PostStart()) > 0 {
-		cmd, cmdOut, _ := executeCommandBackground("docker", []string{"events", "--filter", "event=start", "--filter", "container=" + c.ActualName(adHoc)})
-		if cmd != nil {
+	var (
+		wg                       sync.WaitGroup
+		statusMutex              sync.Mutex
+		status                   int
+		detached, cancelDetached = context.WithCancel(context.Background())
+		stdout                   = newPrefixedWriter(c.CommandsOut(), name+" post-start | ")
+		stderr                   = newPrefixedWriter(c.CommandsErr(), name+" post-start * ")
+	)
+	// Failures are reported right away, but only abort once the
+	// container has been started. Detached steps are not waited for,
+	// as commands they spawned might outlive them.
+	run := func(wait bool, f func()) {
+		if wait {
 			wg.Add(1)
-			go func() {
-				defer func() {
-					handleRecoveredError(recover())
+		}
+		go func() {
+			defer func() {
+				if recoveredStatus := reportRecoveredError(recover()); recoveredStatus != 0 {
+					statusMutex.Lock()
+					if status == 0 {
+						status = recoveredStatus
+					}
+					statusMutex.Unlock()
+				}
+				if wait {
 					wg.Done()
-				}()
-				r := bufio.NewReader(cmdOut)
-				_, _, err := r.ReadLine()
-				cmd.Process.Kill()
-				if err != nil {
-					printNoticef("Could not execute post-start hook for %s: %s.", c.ActualName(adHoc), err)
-				} else {
-					executeHook(c.Hooks().PostStart(), c.ActualName(adHoc))
 				}
 			}()
+			f()
+		}()
+	}
+	run(true, func() {
+		r := bufio.NewReader(cmdOut)
+		_, _, err := r.ReadLine()
+		cmd.Process.Kill()
+		cmd.Wait()
+		if err != nil {
+			if backgroundContext.Err() == nil {
+				printNoticef("Could not execute post-start hook for %s: %s.\n", name, err)
+			}
+			return
+		}
+		env := c.hookEnv("post-start", adHoc)
+		for _, step := range hook {
+			if attached && step.Detach {
+				step := step
+				run(false, func() {
+					executeHookStep(detached, step, name, env, stdout, stderr)
+				})
+			} else {
+				executeHookStep(context.Background(), step, name, env, stdout, stderr)
+			}
+		}
+		if started != nil {
+			started()
+		}
+	})
+
+	return func() {
+		cancelDetached()
+		wg.Wait()
+		stdout.Flush()
+		stderr.Flush()
+		statusMutex.Lock()
+		defer statusMutex.Unlock()
+		if status != 0 {
+			panic(StatusError{status: status})
 		}
 	}
+}
 
-	return &wg
+// When the given (not yet started) container was created or last
+// stopped, according to the clock of the Docker daemon, which might
+// differ from the local one. Its next start event happens after
+// that, while earlier start events happened before.
+func lastStateChange(reference string) time.Time {
+	var last time.Time
+	output := inspectString(reference, "{{.Created}}+++{{.State.StartedAt}}+++{{.State.FinishedAt}}")
+	for _, field := range strings.Split(output, "+++") {
+		if changed, err := time.Parse(time.RFC3339Nano, field); err == nil && changed.After(last) {
+			last = changed
+		}
+	}
+	if last.IsZero() {
+		return time.Now()
+	}
+	return last
 }
 
 // Returns all the flags to be passed to `docker create`
@@ -1205,6 +1381,11 @@ func (c *container) createArgs(cmds []string) []string {
 	for _, label := range c.Label() {
 		args = append(args, "--label", label)
 	}
+	// Ad-hoc containers are labelled so that they can be pruned
+	if adHoc {
+		args = append(args, "--label", adHocLabel+"="+c.PrefixedName())
+		args = append(args, "--label", createdLabel+"="+time.Now().UTC().Format(time.RFC3339))
+	}
 	// LabelFile
 	for _, labelFile := range c.LabelFile() {
 		args = append(args, "--label-file", labelFile)
@@ -1411,43 +1592,45 @@ func (c *container) Start(targeted bool) {
 		if !c.Running() {
 			c.startAcceleratedMounts()
 			fmt.Fprintf(c.CommandsOut(), "Starting container %s ...\n", c.ActualName(adHoc))
-			c.start(adHoc, targeted, detachFlag)
+			c.start(adHoc, targeted, detachFlag, nil)
 		}
 	} else {
-		c.Run([]string{}, targeted, detachFlag)
+		c.Run([]string{}, targeted, detachFlag, nil)
 	}
 }
 
 // Ensure all accelerated mounts used by this container are running.
 func (c *container) startAcceleratedMounts() {
-	for _, volume := range c.Volume() {
-		am := cfg.AcceleratedMount(volume)
-		if accelerationEnabled() && am != nil {
+	if accelerationEnabled() {
+		for _, am := range c.AcceleratedMounts() {
 			am.Run()
 		}
 	}
 }
 
-func (c *container) start(adHoc bool, targeted bool, detachFlag bool) {
-	executeHook(c.Hooks().PreStart(), c.ActualName(adHoc))
+// Accelerated mounts configured for volumes of this container
+func (c *container) AcceleratedMounts() []AcceleratedMount {
+	var mounts []AcceleratedMount
+	for _, volume := range c.Volume() {
+		if am := cfg.AcceleratedMount(volume); am != nil {
+			mounts = append(mounts, am)
+		}
+	}
+	return mounts
+}
 
-	args := []string{"start"}
+func (c *container) start(adHoc bool, targeted bool, detachFlag bool, started func()) {
+	c.executeHook("pre-start", c.Hooks().PreStart(), adHoc)
 
-	// If detach is not configured, it is false by default
-	configDetach := false
-	if c.Detach != nil {
-		configDetach = *c.Detach
-	}
+	args := []string{"start"}
 
 	// It is only possible to attach to targeted containers
-	if targeted {
-		// adHoc always attaches because of --rm
-		if adHoc || (!detachFlag && !configDetach) {
-			args = append(args, "--attach")
-			// Interactive - implies attaching!
-			if c.Stdin_Open || c.Interactive {
-				args = append(args, "--interactive")
-			}
+	attached := targeted && c.attaches(adHoc, detachFlag)
+	if attached {
+		args = append(args, "--attach")
+		// Interactive - implies attaching!
+		if c.Stdin_Open || c.Interactive {
+			args = append(args, "--interactive")
 		}
 	}
 
@@ -1458,37 +1641,81 @@ func (c *container) start(adHoc bool, targeted bool, detachFlag bool) {
 
 	args = append(args, c.ActualName(adHoc))
 
-	wg := c.executePostStartHook(adHoc)
+	waitForPostStartHook := c.executePostStartHook(adHoc, attached, started)
 
 	executeCommand("docker", args, c.CommandsOut(), c.CommandsErr())
 
-	wg.Wait()
+	waitForPostStartHook()
+}
+
+// Whether running the container as target attaches to it
+func (c *container) Attaches(cmds []string, detachFlag bool) bool {
+	return c.attaches(len(cmds) > 0, detachFlag)
+}
+
+func (c *container) attaches(adHoc bool, detachFlag bool) bool {
+	// If detach is not configured, it is false by default
+	configDetach := false
+	if c.Detach != nil {
+		configDetach = *c.Detach
+	}
+	// adHoc always attaches because of --rm
+	return adHoc || (!detachFlag && !configDetach)
 }
 
 // Kill container
 func (c *container) Kill() {
 	if c.Running() {
 		name := c.ActualName(false)
-		executeHook(c.Hooks().PreStop(), name)
+		c.executeHook("pre-stop", c.Hooks().PreStop(), false)
 		fmt.Fprintf(c.CommandsOut(), "Killing container %s ...\n", name)
 		args := []string{"kill", name}
 		executeCommand("docker", args, c.CommandsOut(), c.CommandsErr())
-		executeHook(c.Hooks().PostStop(), name)
+		c.executeHook("post-stop", c.Hooks().PostStop(), false)
 	}
 }
 
 // Stop container
-func (c *container) Stop() {
+func (c *container) Stop(timeout string) {
 	if c.Running() {
 		name := c.ActualName(false)
-		executeHook(c.Hooks().PreStop(), name)
+		c.executeHook("pre-stop", c.Hooks().PreStop(), false)
 		fmt.Fprintf(c.CommandsOut(), "Stopping container %s ...\n", name)
-		args := []string{"stop", name}
-		executeCommand("docker", args, c.CommandsOut(), c.CommandsErr())
-		executeHook(c.Hooks().PostStop(), name)
+		c.stop(timeout)
+		c.executeHook("post-stop", c.Hooks().PostStop(), false)
 	}
 }
 
+// Stops the container gracefully, waiting for the given
+// timeout or else the configured stop timeout before Docker
+// kills it. Reports if the container had to be killed.
+func (c *container) stop(timeout string) {
+	name := c.ActualName(false)
+	if len(timeout) == 0 {
+		timeout = c.StopTimeout()
+	}
+	args := []string{"stop"}
+	if len(timeout) > 0 {
+		args = append(args, "--time", timeout)
+	}
+	args = append(args, name)
+	executeCommand("docker", args, c.CommandsOut(), c.CommandsErr())
+	if !isDryRun() && c.killedOnStop() {
+		printNoticef("Container %s did not stop in time and was killed (SIGKILL).\n", name)
+	}
+}
+
+// A container stopped via SIGKILL exits with 137 (128 + 9). This
+// is expected if SIGKILL is the configured stop signal, or if the
+// container was killed because it ran out of memory.
+func (c *container) killedOnStop() bool {
+	switch strings.TrimPrefix(strings.ToUpper(c.StopSignal()), "SIG") {
+	case "KILL", "9":
+		return false
+	}
+	return inspectString(c.ActualName(false), "{{.State.ExitCode}}+++{{.State.OOMKilled}}") == "137+++false"
+}
+
 // Pause container
 func (c *container) Pause() {
 	if c.Running() {
@@ -1530,18 +1757,34 @@ func (c *container) Exec(cmds []string, privileged bool, user string) {
 	executeCommand("docker", args, c.CommandsOut(), c.CommandsErr())
 }
 
-// Remove container
-func (c *container) Rm(force bool, volumes bool) {
+// Remove container. Running containers are only removed
+// if forced. They are stopped gracefully first when a timeout
+// is given or configured, otherwise they are killed right away.
+// Returns whether the container was removed.
+func (c *container) Rm(force bool, volumes bool, timeout string) bool {
+	return c.rm(force, volumes, timeout, true)
+}
+
+// The rm hooks are skipped when the container is
+// only removed in order to be recreated.
+func (c *container) rm(force bool, volumes bool, timeout string, rmHooks bool) bool {
 	if c.Exists() {
 		name := c.ActualName(false)
 		containerIsRunning := c.Running()
 		if !force && containerIsRunning {
 			fmt.Fprintf(c.CommandsOut(), "Cannot remove running container %s, use --force to remove anyway.\n", name)
-			return
+			return false
+		}
+		if rmHooks {
+			c.executeHook("pre-rm", c.Hooks().PreRm(), false)
 		}
 		args := []string{"rm"}
 		if force && containerIsRunning {
-			executeHook(c.Hooks().PreStop(), name)
+			c.executeHook("pre-stop", c.Hooks().PreStop(), false)
+			if len(timeout) > 0 || len(c.StopTimeout()) > 0 {
+				fmt.Fprintf(c.CommandsOut(), "Stopping container %s ...\n", name)
+				c.stop(timeout)
+			}
 			args = append(args, "--force")
 		}
 		if volumes {
@@ -1553,14 +1796,40 @@ func (c *container) Rm(force bool, volumes bool) {
 		args = append(args, name)
 		executeCommand("docker", args, c.CommandsOut(), c.CommandsErr())
 		if force && containerIsRunning {
-			executeHook(c.Hooks().PostStop(), name)
+			c.executeHook("post-stop", c.Hooks().PostStop(), false)
+		}
+		if rmHooks {
+			c.executeHook("post-rm", c.Hooks().PostRm(), false)
 		}
 		c.id = ""
+		return true
+	}
+	return false
+}
+
+// Remove stopped ad-hoc containers which were created
+// from this container before the given duration.
+func (c *container) PruneAdHoc(olderThan time.Duration) {
+	args := []string{
+		"ps", "--all", "--quiet",
+		"--filter", "label=" + adHocLabel + "=" + c.PrefixedName(),
+		"--filter", "status=created",
+		"--filter", "status=exited",
+		"--filter", "status=dead",
+	}
+	output, _ := commandOutput("docker", args)
+	for _, id := range strings.Fields(output) {
+		fields := strings.Split(inspectString(id, "{{.Name}}+++{{index .Config.Labels \""+createdLabel+"\"}}"), "+++")
+		if len(fields) != 2 || !createdBefore(fields[1], olderThan) {
+			continue
+		}
+		fmt.Fprintf(c.CommandsOut(), "Removing ad-hoc container %s ...\n", strings.TrimPrefix(fields[0], "/"))
+		executeHiddenCommand("docker", []string{"rm", "--volumes", id})
 	}
 }
 
 // Dump container logs
-func (c *container) Logs(follow bool, since string, tail string) (sources []LogSource) {
+func (c *container) Logs(follow bool, since string, until string, tail string) (sources []LogSource) {
 	if c.Exists() {
 		name := c.ActualName(false)
 		args := []string{"logs"}
@@ -1570,6 +1839,9 @@ func (c *container) Logs(follow bool, since string, tail string) (sources []LogS
 		if len(since) > 0 {
 			args = append(args, "--since", since)
 		}
+		if len(until) > 0 {
+			args = append(args, "--until", until)
+		}
 		if len(tail) > 0 && tail != "all" {
 			args = append(args, "--tail", tail)
 		}
@@ -1583,28 +1855,224 @@ func (c *container) Logs(follow bool, since string, tail string) (sources []LogS
 				Stdout: stdout,
 				Stderr: stderr,
 				Name:   name,
+				cmd:    cmd,
 			})
 		}
 	}
 	return
 }
 
-// Push container
-func (c *container) Push() {
-	fmt.Fprintf(c.CommandsOut(), "Pushing image %s ...\n", c.Image())
-	args := []string{"push", c.Image()}
-	executeCommand("docker", args, c.CommandsOut(), c.CommandsErr())
+// Additional references the image is pushed to
+func (c *container) PushReferences() []string {
+	return expandEach(c.RawPush)
+}
+
+// Pushes the image as well as the configured and given additional
+// references, which are tagged first. Returns the digests pushed.
+func (c *container) Push(references []string) []PushedImage {
+	// The tag is pushed, not the digest pinned in the lockfile
+	image := c.UnlockedImage()
+	var pushReferences []string
+	// Digests cannot be pushed
+	if !strings.Contains(image, "@") {
+		pushReferences = append(pushReferences, image)
+	}
+	for _, reference := range append(c.PushReferences(), references...) {
+		reference = expandPushReference(reference, c.Name())
+		if !includes(pushReferences, reference) {
+			pushReferences = append(pushReferences, reference)
+		}
+	}
+
+	c.executeHook("pre-push", c.Hooks().PrePush(), false)
+	var pushed []PushedImage
+	for _, reference := range pushReferences {
+		if reference != image {
+			fmt.Fprintf(c.CommandsOut(), "Tagging image %s as %s ...\n", image, reference)
+			executeCommand("docker", []string{"tag", image, reference}, c.CommandsOut(), c.CommandsErr())
+		}
+		fmt.Fprintf(c.CommandsOut(), "Pushing image %s ...\n", reference)
+		executeCommand("docker", []string{"push", reference}, c.CommandsOut(), c.CommandsErr())
+		pushed = append(pushed, PushedImage{Reference: reference, Digest: pushedDigest(reference)})
+	}
+	c.executeHook("post-push", c.Hooks().PostPush(), false)
+	return pushed
+}
+
+// Digest the given reference was pushed as, if known
+func pushedDigest(reference string) string {
+	output, err := commandOutput("docker", []string{"image", "inspect", "--format={{json .RepoDigests}}", reference})
+	var repoDigests []string
+	if err != nil || json.Unmarshal([]byte(output), &repoDigests) != nil {
+		return ""
+	}
+	repoDigest := repoDigestFor(reference, repoDigests)
+	if at := strings.Index(repoDigest, "@"); at != -1 {
+		return repoDigest[at+1:]
+	}
+	return ""
 }
 
 func (c *container) Hooks() Hooks {
 	return &c.hooks
 }
 
-// Pull image for container
-func (c *container) PullImage() {
-	fmt.Fprintf(c.CommandsOut(), "Pulling image %s ...\n", c.Image())
-	args := []string{"pull", c.Image()}
-	executeCommand("docker", args, c.CommandsOut(), c.CommandsErr())
+// Executes the hook for the given event, passing
+// details about the container in the environment.
+func (c *container) executeHook(event string, hook Hook, adHoc bool) {
+	if len(hook) > 0 {
+		executeHook(hook, c.ActualName(adHoc), c.hookEnv(event, adHoc), c.CommandsOut(), c.CommandsErr())
+	}
+}
+
+// Environment of hooks, exposing the published ports (host port
+// of the first binding) and the IP addresses of the container
+// if it exists, e.g. CRANE_PORT_80_TCP and CRANE_IP_DEFAULT.
+func (c *container) hookEnv(event string, adHoc bool) []string {
+	name := c.ActualName(adHoc)
+	id := containerID(name)
+	env := append(projectHookEnv(event),
+		"CRANE_HOOKED_CONTAINER="+name,
+		"CRANE_SERVICE="+c.Name(),
+		"CRANE_CONTAINER_ID="+id,
+		"CRANE_IMAGE="+c.Image(),
+		"CRANE_AD_HOC="+strconv.FormatBool(adHoc),
+	)
+	if len(id) == 0 {
+		return env
+	}
+	// Networks are referred to by their name in the configuration
+	networkNames := make(map[string]string)
+	for _, networkName := range cfg.NetworkNames() {
+		networkNames[cfg.Network(networkName).ActualName()] = networkName
+	}
+	ip := ""
+	output := inspectString(id, "{{range $p, $b := .NetworkSettings.Ports}}{{if $b}}port:{{$p}}={{(index $b 0).HostPort}} {{end}}{{end}}{{range $n, $s := .NetworkSettings.Networks}}ip:{{$n}}={{$s.IPAddress}} {{end}}")
+	for _, field := range strings.Fields(output) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || len(parts[1]) == 0 {
+			continue
+		}
+		if strings.HasPrefix(parts[0], "port:") {
+			env = append(env, "CRANE_PORT_"+hookEnvName(strings.TrimPrefix(parts[0], "port:"))+"="+parts[1])
+		} else if strings.HasPrefix(parts[0], "ip:") {
+			network := strings.TrimPrefix(parts[0], "ip:")
+			if configName, ok := networkNames[network]; ok {
+				network = configName
+			}
+			env = append(env, "CRANE_IP_"+hookEnvName(network)+"="+parts[1])
+			if len(ip) == 0 {
+				ip = parts[1]
+				env = append(env, "CRANE_IP="+ip)
+			}
+		}
+	}
+	return env
+}
+
+// Pull image for container, retrying on transient failures
+func (c *container) PullImage(retries int) {
+	c.pullImage(c.Image(), retries)
+}
+
+func (c *container) pullImage(image string, retries int) {
+	c.executeHook("pre-pull", c.Hooks().PrePull(), false)
+	fmt.Fprintf(c.CommandsOut(), "Pulling image %s ...\n", image)
+	pullWithRetries(image, retries, c.CommandsOut(), c.CommandsErr())
+	c.executeHook("post-pull", c.Hooks().PostPull(), false)
+}
+
+func (c *container) ImageExists() bool {
+	return len(imageID(c.Image())) > 0
+}
+
+// Returns the fields of the row of `crane images`: service,
+// image, ID, size, creation time and whether it is in use.
+func (c *container) ImageStatus() []string {
+	fields := []string{c.Name(), c.Image(), "-", "-", "-", "-"}
+	args := []string{"image", "inspect", "--format={{.Id}}+++{{.Size}}+++{{.Created}}", c.Image()}
+	output, err := commandOutput("docker", args)
+	if err != nil {
+		return fields
+	}
+	parts := strings.Split(output, "+++")
+	if len(parts) != 3 {
+		return fields
+	}
+	fields[2] = parts[0]
+	if size, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+		fields[3] = humanSize(size)
+	}
+	if created, err := time.Parse(time.RFC3339Nano, parts[2]); err == nil {
+		fields[4] = created.Local().Format("2006-01-02 15:04")
+	}
+	fields[5] = strconv.FormatBool(imageInUse(parts[0]))
+	return fields
+}
+
+// Removes the image as well as dangling images left behind by
+// earlier builds. Pulled images are only removed if requested, as
+// they might be used outside of the project, too. Both the tagged
+// image and the one pinned in the lockfile are removed then. Images
+// in use by containers make the removal fail, unless they should be
+// skipped.
+func (c *container) RemoveImage(danglingOnly bool, unusedOnly bool, pulled bool) {
+	var ids []string
+	names := make(map[string]string)
+	if len(c.BuildParams().Context()) == 0 && !danglingOnly && !pulled {
+		fmt.Fprintf(c.CommandsOut(), "Image %s is not built by Crane, skipping removal ...\n", c.UnlockedImage())
+	} else if !danglingOnly {
+		for _, name := range []string{c.UnlockedImage(), c.Image()} {
+			if id := imageID(name); len(id) > 0 && !includes(ids, id) {
+				ids = append(ids, id)
+				names[id] = name
+			}
+		}
+	}
+	if len(c.BuildParams().Context()) > 0 {
+		args := []string{"image", "ls", "--quiet", "--no-trunc", "--filter", "dangling=true", "--filter", "label=" + serviceLabel + "=" + c.PrefixedName()}
+		output, _ := commandOutput("docker", args)
+		for _, id := range strings.Fields(output) {
+			if !includes(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	for _, id := range ids {
+		name, ok := names[id]
+		if !ok {
+			name = truncateID(strings.TrimPrefix(id, "sha256:"))
+		}
+		if unusedOnly && imageInUse(id) {
+			fmt.Fprintf(c.CommandsOut(), "Image %s is in use, skipping removal ...\n", name)
+			continue
+		}
+		fmt.Fprintf(c.CommandsOut(), "Removing image %s ...\n", name)
+		// Remove by name so that other tags of the image remain
+		executeCommand("docker", []string{"rmi", name}, c.CommandsOut(), c.CommandsErr())
+	}
+}
+
+// Pulls the image and returns the digest reference
+// it is to be pinned to in the lockfile.
+func (c *container) LockImage() string {
+	image := c.UnlockedImage()
+	if strings.Contains(image, "@") {
+		return image
+	}
+	// The pinned digest (if any) must not be used,
+	// as the tag might point to a newer image by now
+	c.pullImage(image, defaultPullRetries)
+	repoDigests, ok := imageRepoDigests(image)
+	if !ok {
+		panic(StatusError{fmt.Errorf("Could not inspect image %s", image), 69})
+	}
+	locked := repoDigestFor(image, repoDigests)
+	if len(locked) == 0 {
+		panic(StatusError{fmt.Errorf("Image %s has no digest, it might not have been pulled from a registry", image), 69})
+	}
+	fmt.Fprintf(c.CommandsOut(), "Pinned image %s to %s\n", image, locked)
+	return locked
 }
 
 func (c *container) PrefixedName() string {
@@ -1644,6 +2112,32 @@ func (c *container) Paused() bool {
 	return inspectBool(c.ID(), "{{.State.Paused}}")
 }
 
+// Containers without a healthcheck are considered
+// healthy as soon as they are running.
+func (c *container) Healthy() bool {
+	if !c.Running() {
+		return false
+	}
+	health := inspectString(c.ID(), "{{if .State.Health}}{{.State.Health.Status}}{{end}}")
+	return health == "" || health == "healthy"
+}
+
+func (c *container) Exited() bool {
+	if !c.Exists() {
+		return false
+	}
+	status := inspectString(c.ID(), "{{.State.Status}}")
+	return status == "exited" || status == "dead"
+}
+
+func (c *container) ExitCode() int {
+	if !c.Exists() {
+		return 0
+	}
+	exitCode, _ := strconv.Atoi(inspectString(c.ID(), "{{.State.ExitCode}}"))
+	return exitCode
+}
+
 // Volume values are bind-mounts if they contain a colon
 // and the part before the colon is not a configured volume.
 func (c *container) BindMounts(volumeNames []string) []string {
@@ -1658,24 +2152,217 @@ func (c *container) BindMounts(volumeNames []string) []string {
 }
 
 // Build image for container
-func (c *container) buildImage(nocache bool) {
-	executeHook(c.Hooks().PreBuild(), c.ActualName(false))
+func (c *container) buildImage(nocache bool, force bool) {
+	hash := c.buildHash()
+	// With `pull`, newer base images are only detected by building
+	if !nocache && !force && !c.BuildParams().Pull && len(hash) > 0 && c.imageBuildHash() == hash {
+		fmt.Fprintf(c.CommandsOut(), "Image %s is up to date, skipping build ...\n", c.Image())
+		return
+	}
+	c.executeHook("pre-build", c.Hooks().PreBuild(), false)
 	fmt.Fprintf(c.CommandsOut(), "Building image %s ...\n", c.Image())
-	args := []string{"build"}
+	args := c.buildArgs(nocache, hash)
+	var env []string
+	if c.BuildParams().Backend() == buildBackendDocker && c.BuildParams().RequiresBuildKit() {
+		env = append(env, "DOCKER_BUILDKIT=1")
+	}
+	executeCommandContext(context.Background(), "docker", args, env, c.CommandsOut(), c.CommandsErr(), 0)
+	c.executeHook("post-build", c.Hooks().PostBuild(), false)
+}
+
+// Returns the arguments to be passed to `docker`, building either
+// with `docker build` or with `docker buildx build`. Buildx either
+// loads the image into Docker, or pushes it to the registry, which
+// is required when building for multiple platforms.
+func (c *container) buildArgs(nocache bool, hash string) []string {
+	params := c.BuildParams()
+	var args []string
+	if params.Backend() == buildBackendBuildx {
+		args = []string{"buildx", "build"}
+		if len(params.Builder()) > 0 {
+			args = append(args, "--builder", params.Builder())
+		}
+		if len(params.Platforms()) > 0 {
+			args = append(args, "--platform", strings.Join(params.Platforms(), ","))
+		}
+		if params.Push {
+			args = append(args, "--push")
+		} else {
+			args = append(args, "--load")
+		}
+		if params.InlineCache {
+			args = append(args, "--cache-to", "type=inline", "--cache-from", "type=registry,ref="+c.Image())
+		}
+	} else {
+		args = []string{"build"}
+	}
 	if nocache {
 		args = append(args, "--no-cache")
 	}
-	args = append(args, "--rm", "--tag="+c.Image())
-	if len(c.BuildParams().File()) > 0 {
-		args = append(args, "--file="+filepath.FromSlash(c.BuildParams().Context()+"/"+c.BuildParams().File()))
+	if params.Pull {
+		args = append(args, "--pull")
+	}
+	if params.Backend() == buildBackendDocker {
+		args = append(args, "--rm")
+	}
+	args = append(args, "--tag="+c.Image())
+	if len(params.File()) > 0 {
+		args = append(args, "--file="+filepath.FromSlash(params.Context()+"/"+params.File()))
+	}
+	if len(params.Target()) > 0 {
+		args = append(args, "--target", params.Target())
 	}
-	for _, arg := range c.BuildParams().BuildArgs() {
+	for _, arg := range params.BuildArgs() {
 		args = append(args, "--build-arg", arg)
 	}
+	for _, cacheFrom := range params.CacheFrom() {
+		args = append(args, "--cache-from", cacheFrom)
+	}
+	for _, label := range params.Label() {
+		args = append(args, "--label", label)
+	}
+	if len(params.Network()) > 0 {
+		args = append(args, "--network", params.Network())
+	}
+	if len(params.ShmSize()) > 0 {
+		args = append(args, "--shm-size", params.ShmSize())
+	}
+	for _, addHost := range params.AddHost() {
+		args = append(args, "--add-host", addHost)
+	}
+	for _, ssh := range params.SSH() {
+		args = append(args, "--ssh", ssh)
+	}
+	for _, secret := range params.Secret() {
+		args = append(args, "--secret", secret)
+	}
+	// Allows to find dangling images left behind by earlier builds
+	args = append(args, "--label", serviceLabel+"="+c.PrefixedName())
+	if len(hash) > 0 {
+		args = append(args, "--label", buildHashLabel+"="+hash)
+	}
 
-	args = append(args, c.BuildParams().Context())
-	executeCommand("docker", args, c.CommandsOut(), c.CommandsErr())
-	executeHook(c.Hooks().PostBuild(), c.ActualName(false))
+	return append(args, params.Context())
+}
+
+// Hash of the build context, the Dockerfile, the build arguments and
+// the IDs of the images the build depends on, so that an image is
+// rebuilt after its base image changed. Empty if the context is not
+// a local directory (e.g. a Git URL).
+func (c *container) buildHash() string {
+	dir := c.buildContextDir()
+	if len(dir) == 0 {
+		return ""
+	}
+	args := c.buildArgs(false, "")
+	for _, image := range c.buildDependencyImages() {
+		if id := imageID(image); len(id) > 0 {
+			args = append(args, image+"@"+id)
+		}
+	}
+	return buildContextHash(dir, c.dockerfilePath(), args)
+}
+
+// Absolute path of the build context. Empty if
+// the context is not a local directory.
+func (c *container) buildContextDir() string {
+	dir := c.BuildParams().Context()
+	if len(dir) == 0 {
+		return ""
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(cfg.Path(), dir)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return ""
+	}
+	return dir
+}
+
+func (c *container) dockerfilePath() string {
+	dockerfile := "Dockerfile"
+	if len(c.BuildParams().File()) > 0 {
+		dockerfile = c.BuildParams().File()
+	}
+	return filepath.Join(c.buildContextDir(), filepath.FromSlash(dockerfile))
+}
+
+// Images referenced via FROM in the Dockerfile
+func (c *container) BaseImages() []string {
+	if len(c.buildContextDir()) == 0 {
+		return nil
+	}
+	return dockerfileBaseImages(c.dockerfilePath(), c.BuildParams().BuildArgs())
+}
+
+// Images the build depends on: those referenced via FROM
+// and those of the services given in `build.depends_on`.
+func (c *container) buildDependencyImages() []string {
+	images := c.BaseImages()
+	for _, name := range c.BuildParams().DependsOn() {
+		if dependency := cfg.Container(name); dependency != nil {
+			images = append(images, dependency.Image())
+		}
+	}
+	return images
+}
+
+// Hash of the build context the existing image was built from
+func (c *container) imageBuildHash() string {
+	args := []string{"image", "inspect", "--format={{index .Config.Labels \"" + buildHashLabel + "\"}}", c.Image()}
+	output, err := commandOutput("docker", args)
+	if err != nil {
+		return ""
+	}
+	return output
+}
+
+// ID of the given image if it exists locally
+func imageID(image string) string {
+	output, err := commandOutput("docker", []string{"image", "inspect", "--format={{.Id}}", image})
+	if err != nil {
+		return ""
+	}
+	return output
+}
+
+// Repository digests of the given local image.
+// Returns false if the image does not exist.
+func imageRepoDigests(image string) ([]string, bool) {
+	var repoDigests []string
+	output, err := commandOutput("docker", []string{"image", "inspect", "--format={{json .RepoDigests}}", image})
+	if err != nil {
+		return nil, false
+	}
+	if err := json.Unmarshal([]byte(output), &repoDigests); err != nil {
+		panic(StatusError{fmt.Errorf("Could not read digests of image %s: %v", image, err), 69})
+	}
+	return repoDigests, true
+}
+
+// Whether containers were created from the given image (or
+// images based on it)
+func imageInUse(id string) bool {
+	output, err := commandOutput("docker", []string{"ps", "--all", "--quiet", "--filter", "ancestor=" + id})
+	return err == nil && len(output) > 0
+}
+
+// Formats the given number of bytes like Docker does, with three
+// significant digits. The value is rounded before choosing the unit,
+// so that e.g. 999999 bytes are shown as 1MB rather than 1000kB.
+func humanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for {
+		value, _ = strconv.ParseFloat(fmt.Sprintf("%.3g", value), 64)
+		if value < 1000 || unit == len(units)-1 {
+			break
+		}
+		value /= 1000
+		unit++
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64) + units[unit]
 }
 
 func actualVolumeArg(volume string) string {
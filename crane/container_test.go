@@ -3,6 +3,7 @@ package crane
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -149,6 +150,33 @@ func TestImage(t *testing.T) {
 	assert.NotEqual(t, "localhost:5000/foo/image-f@sha256:rc-1", containerMap["digest"].Image())
 }
 
+func TestLockedImage(t *testing.T) {
+	defer func() {
+		*lockedFlag = false
+	}()
+	cfg = &config{
+		lock: &lockFile{Images: map[string]string{"postgres:11": "postgres@sha256:aaa"}},
+	}
+	locked := &container{RawName: "db", RawImage: "postgres:11"}
+	unlocked := &container{RawName: "web", RawImage: "nginx"}
+	built := &container{RawName: "app", RawImage: "postgres:11", RawBuild: BuildParameters{RawContext: "app"}}
+
+	assert.Equal(t, "postgres@sha256:aaa", locked.Image())
+	assert.Equal(t, "postgres:11", locked.UnlockedImage())
+	assert.Equal(t, "nginx", unlocked.Image())
+	assert.Equal(t, "postgres:11", built.Image())
+
+	*lockedFlag = true
+	assert.Equal(t, "postgres@sha256:aaa", locked.Image())
+	assert.Panics(t, func() {
+		unlocked.Image()
+	})
+	assert.Equal(t, "postgres:11", built.Image())
+	// The status shows the configured tag
+	assert.Equal(t, "postgres:11", locked.Status()[0][1])
+	assert.Equal(t, "nginx", unlocked.Status()[0][1])
+}
+
 func TestVolume(t *testing.T) {
 	var c *container
 	// Absolute path
@@ -321,3 +349,133 @@ func TestBuildArgs(t *testing.T) {
 	cfg = &config{path: "foo"}
 	assert.Equal(t, "key1=value1", c.BuildParams().BuildArgs()[0])
 }
+
+func TestStopTimeout(t *testing.T) {
+	var c *container
+	c = &container{}
+	assert.Equal(t, "", c.StopTimeout())
+	c = &container{RawStopTimeout: "30"}
+	assert.Equal(t, "30", c.StopTimeout())
+	c = &container{RawStop_Grace_Period: "1m30s"}
+	assert.Equal(t, "90", c.StopTimeout())
+	c = &container{RawStopTimeout: "5", RawStop_Grace_Period: "1m30s"}
+	assert.Equal(t, "5", c.StopTimeout())
+}
+
+func TestAdHocLabels(t *testing.T) {
+	c := &container{RawName: "web", RawImage: "nginx"}
+	cfg = &config{prefix: "p_", uniqueID: "123"}
+	args := c.createArgs([]string{"ls"})
+	assert.Contains(t, args, adHocLabel+"=p_web")
+	assert.Contains(t, args, "p_web-123")
+	args = c.createArgs([]string{})
+	assert.NotContains(t, args, adHocLabel+"=p_web")
+}
+
+func TestBuildImageArgs(t *testing.T) {
+	var c *container
+	cfg = &config{path: "foo"}
+	c = &container{RawName: "a", RawBuild: BuildParameters{RawContext: "ctx"}}
+	assert.Equal(t, []string{"build", "--rm", "--tag=a", "--label", serviceLabel + "=a", "ctx"}, c.buildArgs(false, ""))
+	assert.False(t, c.BuildParams().RequiresBuildKit())
+
+	c = &container{RawName: "a", RawBuild: BuildParameters{
+		RawContext:    "ctx",
+		RawDockerfile: "Dockerfile.dev",
+		RawArgs:       []interface{}{"key1=value1"},
+		RawTarget:     "dev",
+		RawCache_From: []string{"a:latest"},
+		RawLabels:     []interface{}{"com.example=a"},
+		RawNetwork:    "host",
+		RawShm_Size:   "1g",
+		RawExtraHosts: []string{"somehost:162.242.195.82"},
+		Pull:          true,
+		RawSSH:        []string{"default"},
+		RawSecrets:    []string{"id=npmrc,src=.npmrc"},
+	}}
+	assert.Equal(t, []string{
+		"build",
+		"--no-cache",
+		"--pull",
+		"--rm", "--tag=a",
+		"--file=" + filepath.FromSlash("ctx/Dockerfile.dev"),
+		"--target", "dev",
+		"--build-arg", "key1=value1",
+		"--cache-from", "a:latest",
+		"--label", "com.example=a",
+		"--network", "host",
+		"--shm-size", "1g",
+		"--add-host", "somehost:162.242.195.82",
+		"--ssh", "default",
+		"--secret", "id=npmrc,src=.npmrc",
+		"--label", serviceLabel + "=a",
+		"--label", buildHashLabel + "=abc",
+		"ctx",
+	}, c.buildArgs(true, "abc"))
+	assert.True(t, c.BuildParams().RequiresBuildKit())
+}
+
+func TestHumanSize(t *testing.T) {
+	sizes := []struct {
+		size  int64
+		human string
+	}{
+		{0, "0B"},
+		{999, "999B"},
+		{1000, "1kB"},
+		{1499, "1.5kB"},
+		{999499, "999kB"},
+		{999500, "1MB"},
+		{999999, "1MB"},
+		{72800000, "72.8MB"},
+		{999999999, "1GB"},
+		{1234567890, "1.23GB"},
+		{12345678901234, "12.3TB"},
+		{1234567890123456, "1230TB"},
+	}
+	for _, s := range sizes {
+		assert.Equal(t, s.human, humanSize(s.size), "size %d", s.size)
+	}
+}
+
+func TestBuildxImageArgs(t *testing.T) {
+	var c *container
+	cfg = &config{path: "foo"}
+	c = &container{RawName: "a", RawBuild: BuildParameters{RawContext: "ctx", RawBackend: "buildx"}}
+	assert.Equal(t, []string{"buildx", "build", "--load", "--tag=a", "--label", serviceLabel + "=a", "ctx"}, c.buildArgs(false, ""))
+
+	c = &container{RawName: "a", RawBuild: BuildParameters{
+		RawContext:   "ctx",
+		RawBackend:   "buildx",
+		RawBuilder:   "multiarch",
+		RawPlatforms: []string{"linux/amd64", "linux/arm64"},
+		Push:         true,
+		InlineCache:  true,
+	}}
+	assert.Equal(t, []string{
+		"buildx", "build",
+		"--builder", "multiarch",
+		"--platform", "linux/amd64,linux/arm64",
+		"--push",
+		"--cache-to", "type=inline", "--cache-from", "type=registry,ref=a",
+		"--tag=a",
+		"--label", serviceLabel + "=a",
+		"ctx",
+	}, c.buildArgs(false, ""))
+
+	// The --builder flag only applies to buildx builds
+	builder := "other"
+	originalBuilderFlag := builderFlag
+	builderFlag = &builder
+	defer func() { builderFlag = originalBuilderFlag }()
+	c = &container{RawName: "a", RawBuild: BuildParameters{RawContext: "ctx", RawBackend: "buildx"}}
+	assert.Equal(t, []string{"buildx", "build", "--builder", "other", "--load", "--tag=a", "--label", serviceLabel + "=a", "ctx"}, c.buildArgs(false, ""))
+	c = &container{RawName: "a", RawBuild: BuildParameters{RawContext: "ctx"}}
+	assert.Equal(t, "", c.BuildParams().Builder())
+	assert.Equal(t, []string{"build", "--rm", "--tag=a", "--label", serviceLabel + "=a", "ctx"}, c.buildArgs(false, ""))
+
+	c = &container{RawName: "a", RawBuild: BuildParameters{RawContext: "ctx", RawBackend: "kaniko"}}
+	assert.Panics(t, func() {
+		c.buildArgs(false, "")
+	})
+}
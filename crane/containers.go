@@ -2,13 +2,16 @@ package crane
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"text/tabwriter"
+	"time"
 
 	"github.com/bjaglin/multiplexio"
 	ansi "github.com/fatih/color"
@@ -16,6 +19,11 @@ import (
 
 type Containers []Container
 
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
 func (containers Containers) Reversed() Containers {
 	var reversed []Container
 	for i := len(containers) - 1; i >= 0; i-- {
@@ -24,8 +32,9 @@ func (containers Containers) Reversed() Containers {
 	return reversed
 }
 
-// Provision containers.
-func (containers Containers) Provision(nocache bool, parallel int) {
+// Provision containers. Images are built after the images they
+// build upon, which is respected when provisioning in parallel, too.
+func (containers Containers) Provision(nocache bool, force bool, parallel int) {
 	var (
 		throttle = make(chan struct{}, parallel)
 		wg       sync.WaitGroup
@@ -36,7 +45,12 @@ func (containers Containers) Provision(nocache bool, parallel int) {
 		// does not feel it's stuck
 		fmt.Println("Provisioning containers ...")
 	}
-	for _, container := range containers.stripProvisioningDuplicates() {
+	ordered, dependencies := containers.stripProvisioningDuplicates().buildOrder()
+	done := make(map[Container]chan struct{})
+	for _, container := range ordered {
+		done[container] = make(chan struct{})
+	}
+	for _, container := range ordered {
 		if parallel > 0 {
 			throttle <- struct{}{}
 		}
@@ -47,60 +61,273 @@ func (containers Containers) Provision(nocache bool, parallel int) {
 				out.WriteTo(os.Stdout)
 				err.WriteTo(os.Stderr)
 				handleRecoveredError(recover())
+				close(done[container])
 				if parallel > 0 {
 					<-throttle
 				}
 				wg.Done()
 				container.SetCommandsOutput(nil, nil)
 			}()
+			// Dependencies come first in the order, so they
+			// are provisioning already or have been provisioned
+			for _, dependency := range dependencies[container] {
+				<-done[dependency]
+			}
 			if parallel != 1 {
 				// Prevent parallel provisioning output interlacing
 				// by redirecting the outputs to buffers
 				container.SetCommandsOutput(&out, &err)
 			}
-			container.Provision(nocache)
+			container.Provision(nocache, force)
 		}(container)
 	}
 	wg.Wait()
 }
 
-// Dump container logs.
-func (containers Containers) Logs(follow bool, timestamps bool, tail string, colorize bool, since string) {
+// Pull images, optionally only those missing locally. When pulling
+// in parallel, a line per image reports the start and the end, and
+// the progress of long pulls is reported periodically. The output
+// of `docker pull` is only shown if pulling fails.
+func (containers Containers) PullImage(missing bool, retries int, parallel int) {
+	var pending Containers
+	for _, container := range containers.stripProvisioningDuplicates() {
+		if len(container.BuildParams().Context()) > 0 {
+			continue
+		}
+		if missing && container.ImageExists() {
+			fmt.Printf("Image %s is present, skipping pull ...\n", container.Image())
+			continue
+		}
+		pending = append(pending, container)
+	}
+	var (
+		throttle  = make(chan struct{}, parallel)
+		wg        sync.WaitGroup
+		lock      sync.Mutex
+		completed = 0
+	)
+	for _, container := range pending {
+		if parallel > 0 {
+			throttle <- struct{}{}
+		}
+		wg.Add(1)
+		go func(container Container) {
+			var out, err bytes.Buffer
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					out.WriteTo(os.Stdout)
+					err.WriteTo(os.Stderr)
+					handleRecoveredError(recovered)
+				}
+				if parallel > 0 {
+					<-throttle
+				}
+				wg.Done()
+				container.SetCommandsOutput(nil, nil)
+			}()
+			if parallel == 1 {
+				container.PullImage(retries)
+				return
+			}
+			fmt.Printf("Pulling image %s ...\n", container.Image())
+			started := time.Now()
+			progress := &pullProgress{}
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				ticker := time.NewTicker(pullProgressInterval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-done:
+						return
+					case <-backgroundContext.Done():
+						return
+					case <-ticker.C:
+						details := time.Since(started).Round(time.Second).String()
+						if summary := progress.summary(); len(summary) > 0 {
+							details = summary + ", " + details
+						}
+						fmt.Printf("Still pulling image %s (%s) ...\n", container.Image(), details)
+					}
+				}
+			}()
+			container.SetCommandsOutput(io.MultiWriter(&out, progress), &err)
+			container.PullImage(retries)
+			lock.Lock()
+			completed++
+			fmt.Printf("Pulled image %s in %s (%d/%d)\n", container.Image(), time.Since(started).Round(100*time.Millisecond), completed, len(pending))
+			lock.Unlock()
+		}(container)
+	}
+	wg.Wait()
+}
+
+// Push images, along with the given additional references, and
+// print a summary of the digests pushed.
+func (containers Containers) Push(references []string, parallel int) {
+	var (
+		throttle = make(chan struct{}, parallel)
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		pushed   = make(map[Container][]PushedImage)
+	)
+	deduplicated := containers.stripProvisioningDuplicates()
+	// Services sharing an image are pushed only once, but
+	// to the references of each of them
+	additionalReferences := make(map[Container][]string)
+	for _, container := range containers {
+		for _, kept := range deduplicated {
+			if kept != container && provisioningKey(kept) == provisioningKey(container) {
+				for _, reference := range append(container.PushReferences(), references...) {
+					additionalReferences[kept] = append(additionalReferences[kept], expandPushReference(reference, container.Name()))
+				}
+				break
+			}
+		}
+	}
+	for _, container := range deduplicated {
+		if parallel > 0 {
+			throttle <- struct{}{}
+		}
+		wg.Add(1)
+		go func(container Container) {
+			var out, err bytes.Buffer
+			defer func() {
+				out.WriteTo(os.Stdout)
+				err.WriteTo(os.Stderr)
+				handleRecoveredError(recover())
+				if parallel > 0 {
+					<-throttle
+				}
+				wg.Done()
+				container.SetCommandsOutput(nil, nil)
+			}()
+			if parallel != 1 {
+				// Prevent parallel push output interlacing
+				// by redirecting the outputs to buffers
+				container.SetCommandsOutput(&out, &err)
+			}
+			images := container.Push(append(append([]string{}, references...), additionalReferences[container]...))
+			lock.Lock()
+			pushed[container] = images
+			lock.Unlock()
+		}(container)
+	}
+	wg.Wait()
+	if isDryRun() {
+		return
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "\nSERVICE\tIMAGE\tDIGEST")
+	for _, container := range deduplicated {
+		for _, image := range pushed[container] {
+			digest := image.Digest
+			if len(digest) == 0 {
+				digest = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", container.Name(), image.Reference, digest)
+		}
+	}
+	w.Flush()
+}
+
+// Dump container logs, either as (colored) text prefixed with the
+// container name, or as one JSON object per line. If an output
+// directory is given, the logs of each container are written to
+// their own file in there instead of STDOUT. Lines not matching
+// the filter (if any) are skipped.
+func (containers Containers) Logs(follow bool, timestamps bool, tail string, colorize bool, since string, until string, filter *logFilter, format string, outputDir string) {
 	var (
 		sources         = make([]multiplexio.Source, 0, 2*len(containers))
 		maxPrefixLength = strconv.Itoa(containers.maxNameLength())
+		files           = make(map[string]*os.File)
 	)
-	appendSources := func(reader io.Reader, color *ansi.Color, name string, separator string) {
-		if reader != nil {
-			prefix := fmt.Sprintf("%"+maxPrefixLength+"s "+separator+" ", name)
-			sources = append(sources, multiplexio.Source{
-				Reader: reader,
-				Write:  write(prefix, color, timestamps),
-			})
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	logFile := func(name string) *os.File {
+		if file, ok := files[name]; ok {
+			return file
 		}
+		extension := ".log"
+		if format == logFormatJSON {
+			extension = ".jsonl"
+		}
+		filename := filepath.Join(outputDir, name+extension)
+		file, err := os.Create(filename)
+		if err != nil {
+			panic(StatusError{err, 73})
+		}
+		printInfof("Writing logs of %s to %s ...\n", name, filename)
+		files[name] = file
+		return file
 	}
-	counter := 0
-	for _, container := range containers {
-		var (
-			logs        = container.Logs(follow, since, tail)
-			stdoutColor *ansi.Color
-			stderrColor *ansi.Color
-		)
-		for _, log := range logs {
-			if colorize {
-				// red has a negative/error connotation, so skip it
-				ansiAttribute := ansi.Attribute(int(ansi.FgGreen) + counter%int(ansi.FgWhite-ansi.FgGreen))
-				stdoutColor = ansi.New(ansiAttribute)
-				// To synchronize their output, we need to multiplex stdout & stderr
-				// onto the same stream. Unfortunately, that means that the user won't
-				// be able to pipe them separately, so we use bold as a distinguishing
-				// characteristic.
-				stderrColor = ansi.New(ansiAttribute).Add(ansi.Bold)
+	appendSources := func(reader io.Reader, color *ansi.Color, name string, stream string, separator string) {
+		if reader == nil {
+			return
+		}
+		var writeToken func(dest io.Writer, token []byte) (n int, err error)
+		if format == logFormatJSON {
+			writeToken = writeJSON(name, stream)
+		} else if len(outputDir) > 0 {
+			writeToken = write("", nil, timestamps)
+		} else {
+			prefix := fmt.Sprintf("%"+maxPrefixLength+"s "+separator+" ", name)
+			writeToken = write(prefix, color, timestamps)
+		}
+		if len(outputDir) > 0 {
+			file := logFile(name)
+			formatToken := writeToken
+			// Nothing is written to the aggregated output
+			writeToken = func(dest io.Writer, token []byte) (n int, err error) {
+				_, err = formatToken(file, token)
+				return 0, err
 			}
-			appendSources(log.Stdout, stdoutColor, log.Name, "|")
-			appendSources(log.Stderr, stderrColor, log.Name, "*")
-			counter += 1
 		}
+		if filter != nil {
+			writeToken = filter.wrap(writeToken)
+		}
+		sources = append(sources, multiplexio.Source{
+			Reader: reader,
+			Write:  writeToken,
+		})
+	}
+	if len(outputDir) > 0 {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			panic(StatusError{err, 73})
+		}
+	}
+	var logs []LogSource
+	if follow {
+		// Keep following containers when they are restarted or recreated
+		logs = followLogs(containers, since, until, tail)
+	} else {
+		for _, container := range containers {
+			logs = append(logs, container.Logs(false, since, until, tail)...)
+		}
+	}
+	var (
+		stdoutColor *ansi.Color
+		stderrColor *ansi.Color
+	)
+	for counter, log := range logs {
+		if colorize {
+			// red has a negative/error connotation, so skip it
+			ansiAttribute := ansi.Attribute(int(ansi.FgGreen) + counter%int(ansi.FgWhite-ansi.FgGreen))
+			stdoutColor = ansi.New(ansiAttribute)
+			// To synchronize their output, we need to multiplex stdout & stderr
+			// onto the same stream. Unfortunately, that means that the user won't
+			// be able to pipe them separately, so we use bold as a distinguishing
+			// characteristic.
+			stderrColor = ansi.New(ansiAttribute).Add(ansi.Bold)
+		}
+		appendSources(log.Stdout, stdoutColor, log.Name, "stdout", "|")
+		appendSources(log.Stderr, stderrColor, log.Name, "stderr", "*")
 	}
 	if len(sources) > 0 {
 		aggregatedReader := multiplexio.NewReader(multiplexio.Options{}, sources...)
@@ -125,6 +352,21 @@ func (containers Containers) Status(notrunc bool) {
 	w.Flush()
 }
 
+// Images of containers.
+func (containers Containers) Images(notrunc bool) {
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "SERVICE\tIMAGE\tID\tSIZE\tCREATED\tIN USE")
+	for _, container := range containers.stripProvisioningDuplicates() {
+		fields := container.ImageStatus()
+		if !notrunc {
+			fields[2] = truncateID(strings.TrimPrefix(fields[2], "sha256:"))
+		}
+		fmt.Fprintf(w, "%s\n", strings.Join(fields, "\t"))
+	}
+	w.Flush()
+}
+
 // Return the length of the longest container name.
 func (containers Containers) maxNameLength() (maxPrefixLength int) {
 	for _, container := range containers {
@@ -136,14 +378,27 @@ func (containers Containers) maxNameLength() (maxPrefixLength int) {
 	return
 }
 
+// Moves the targeted containers which are attached to behind all
+// other containers, so that everything else is up once they are
+// started. Otherwise, the given order is kept.
+func (containers Containers) attachedLast(targeted []string, cmds []string, detach bool) (ordered Containers) {
+	var attached Containers
+	for _, container := range containers {
+		if includes(targeted, container.Name()) && container.Attaches(cmds, detach) {
+			attached = append(attached, container)
+		} else {
+			ordered = append(ordered, container)
+		}
+	}
+	return append(ordered, attached...)
+}
+
 // returns another list of containers, stripping out containers which
 // would trigger some commands more than once for provisioning.
 func (containers Containers) stripProvisioningDuplicates() (deduplicated Containers) {
 	seenProvisioningKeys := make(map[string]bool)
 	for _, container := range containers {
-		// for 2 containers that would the same provisioning
-		// commands, the key should be equal
-		key := container.BuildParams().Context() + "#" + container.Image()
+		key := provisioningKey(container)
 		if _, ok := seenProvisioningKeys[key]; !ok {
 			deduplicated = append(deduplicated, container)
 			seenProvisioningKeys[key] = true
@@ -152,6 +407,83 @@ func (containers Containers) stripProvisioningDuplicates() (deduplicated Contain
 	return
 }
 
+// for 2 containers that would the same provisioning
+// commands, the key should be equal
+func provisioningKey(container Container) string {
+	return container.BuildParams().Context() + "#" + container.Image()
+}
+
+// Sorts the containers so that each image is built after the images
+// it builds upon, either via FROM in its Dockerfile or via
+// `build.depends_on`. Otherwise, the given order is kept. Also
+// returns the containers each container has to wait for.
+func (containers Containers) buildOrder() (ordered Containers, dependencies map[Container][]Container) {
+	dependencies = make(map[Container][]Container)
+	byImage := make(map[string]Container)
+	byName := make(map[string]Container)
+	for _, container := range containers {
+		// FROM refers to the tag, not to the pinned digest
+		byImage[normalizeImageName(container.UnlockedImage())] = container
+		byName[container.Name()] = container
+	}
+	addDependency := func(container Container, dependency Container) {
+		if dependency != nil && dependency != container && !containsContainer(dependencies[container], dependency) {
+			dependencies[container] = append(dependencies[container], dependency)
+		}
+	}
+	for _, container := range containers {
+		if len(container.BuildParams().Context()) == 0 {
+			continue
+		}
+		for _, image := range container.BaseImages() {
+			addDependency(container, byImage[normalizeImageName(image)])
+		}
+		for _, name := range container.BuildParams().DependsOn() {
+			dependency := byName[name]
+			if dependency == nil {
+				// The service might have been stripped as duplicate
+				if configured := cfg.Container(name); configured != nil {
+					dependency = byImage[normalizeImageName(configured.UnlockedImage())]
+				}
+			}
+			addDependency(container, dependency)
+		}
+	}
+
+	visiting := make(map[Container]bool)
+	visited := make(map[Container]bool)
+	var visit func(container Container, path []string)
+	visit = func(container Container, path []string) {
+		path = append(path, container.Name())
+		if visiting[container] {
+			panic(StatusError{fmt.Errorf("Build dependencies form a cycle: %s", strings.Join(path, " -> ")), 65})
+		}
+		if visited[container] {
+			return
+		}
+		visiting[container] = true
+		for _, dependency := range dependencies[container] {
+			visit(dependency, path)
+		}
+		visiting[container] = false
+		visited[container] = true
+		ordered = append(ordered, container)
+	}
+	for _, container := range containers {
+		visit(container, nil)
+	}
+	return
+}
+
+func containsContainer(containers []Container, container Container) bool {
+	for _, c := range containers {
+		if c == container {
+			return true
+		}
+	}
+	return false
+}
+
 func truncateID(id string) string {
 	shortLen := 12
 	if len(id) < shortLen {
@@ -172,6 +504,86 @@ func (c *countingWriter) Write(p []byte) (n int, err error) {
 	return
 }
 
+// wraps an io.Writer, prefixing each line. Lines are buffered
+// and written at once, so that they do not interleave with
+// other output written to the same destination.
+type prefixedWriter struct {
+	mutex  sync.Mutex
+	dest   io.Writer
+	prefix []byte
+	buffer []byte
+}
+
+func newPrefixedWriter(dest io.Writer, prefix string) *prefixedWriter {
+	return &prefixedWriter{dest: dest, prefix: []byte(prefix)}
+}
+
+func (w *prefixedWriter) Write(p []byte) (n int, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.buffer = append(w.buffer, p...)
+	for {
+		i := bytes.IndexByte(w.buffer, '\n')
+		if i < 0 {
+			break
+		}
+		line := append(append([]byte{}, w.prefix...), w.buffer[:i+1]...)
+		w.buffer = w.buffer[i+1:]
+		if _, err = w.dest.Write(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// writes the last line if it was not terminated
+func (w *prefixedWriter) Flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.buffer) > 0 {
+		line := append(append([]byte{}, w.prefix...), w.buffer...)
+		w.dest.Write(append(line, '\n'))
+		w.buffer = nil
+	}
+}
+
+// A log line as written by `logs --format json`
+type logEntry struct {
+	Container string `json:"container"`
+	Stream    string `json:"stream"`
+	Timestamp string `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// Splits a line of `docker logs -t` into its timestamp and message.
+func splitLogLine(token []byte) (timestamp string, message string) {
+	line := string(token)
+	space := strings.IndexByte(line, ' ')
+	if space == -1 {
+		return "", line
+	}
+	// timestamps are wrapped in [] for events streamed in
+	// real time during a `docker logs -f`
+	return strings.Trim(line[:space], "[]"), line[space+1:]
+}
+
+// returns a function that will write the line as a JSON object
+func writeJSON(name string, stream string) func(dest io.Writer, token []byte) (n int, err error) {
+	return func(dest io.Writer, token []byte) (n int, err error) {
+		timestamp, message := splitLogLine(token)
+		line, err := json.Marshal(logEntry{
+			Container: name,
+			Stream:    stream,
+			Timestamp: timestamp,
+			Message:   message,
+		})
+		if err != nil {
+			return 0, err
+		}
+		return dest.Write(append(line, '\n'))
+	}
+}
+
 // returns a function that will format and writes the line extracted from the logs of a given container
 func write(prefix string, color *ansi.Color, timestamps bool) func(dest io.Writer, token []byte) (n int, err error) {
 	return func(dest io.Writer, token []byte) (n int, err error) {
@@ -1,6 +1,10 @@
 package crane
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,3 +37,73 @@ func TestProvisioningDuplicates(t *testing.T) {
 	assert.Len(t, deduplicated, 6)
 	assert.Len(t, containers, 10) // input was not mutated - further operations won't be affected
 }
+
+func TestPrefixedWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newPrefixedWriter(&out, "a | ")
+	w.Write([]byte("foo\nba"))
+	assert.Equal(t, "a | foo\n", out.String())
+	w.Write([]byte("r\nbaz"))
+	assert.Equal(t, "a | foo\na | bar\n", out.String())
+	w.Flush()
+	assert.Equal(t, "a | foo\na | bar\na | baz\n", out.String())
+	w.Flush()
+	assert.Equal(t, "a | foo\na | bar\na | baz\n", out.String())
+}
+
+func TestBuildOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crane-build-order")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{"base": "FROM alpine", "app": "FROM project/base", "tool": "FROM debian"} {
+		os.MkdirAll(filepath.Join(dir, name), 0755)
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name, "Dockerfile"), []byte(content), 0644))
+	}
+	cfg = &config{path: dir}
+	app := &container{RawName: "app", RawImage: "app", RawBuild: BuildParameters{RawContext: "app"}}
+	tool := &container{RawName: "tool", RawImage: "tool", RawBuild: BuildParameters{RawContext: "tool", RawDependsOn: []string{"app"}}}
+	base := &container{RawName: "base", RawImage: "project/base:latest", RawBuild: BuildParameters{RawContext: "base"}}
+	db := &container{RawName: "db", RawImage: "postgres"}
+
+	ordered, dependencies := Containers{tool, db, app, base}.buildOrder()
+	assert.Equal(t, Containers{base, app, tool, db}, ordered)
+	assert.Equal(t, []Container{base}, dependencies[app])
+	assert.Equal(t, []Container{app}, dependencies[tool])
+	assert.Empty(t, dependencies[base])
+
+	base.RawBuild.RawDependsOn = []string{"tool"}
+	assert.Panics(t, func() {
+		Containers{tool, app, base}.buildOrder()
+	})
+}
+
+func TestAttachedLast(t *testing.T) {
+	detach := true
+	db := &container{RawName: "db"}
+	web := &container{RawName: "web"}
+	worker := &container{RawName: "worker", Detach: &detach}
+	cache := &container{RawName: "cache"}
+	containers := Containers{db, web, worker, cache}
+
+	// Only targeted containers are attached to
+	assert.Equal(t, Containers{db, worker, cache, web}, containers.attachedLast([]string{"web", "worker"}, []string{}, false))
+	// Unless configured otherwise
+	assert.Equal(t, containers, containers.attachedLast([]string{"web", "worker"}, []string{}, true))
+	// Ad-hoc containers are always attached to
+	assert.Equal(t, Containers{db, cache, web, worker}, containers.attachedLast([]string{"web", "worker"}, []string{"sh"}, true))
+}
+
+func TestSplitLogLine(t *testing.T) {
+	timestamp, message := splitLogLine([]byte("2019-05-01T10:00:00.000000001Z hello world"))
+	assert.Equal(t, "2019-05-01T10:00:00.000000001Z", timestamp)
+	assert.Equal(t, "hello world", message)
+	timestamp, message = splitLogLine([]byte("[2019-05-01T10:00:00.000000001Z] hello"))
+	assert.Equal(t, "2019-05-01T10:00:00.000000001Z", timestamp)
+	assert.Equal(t, "hello", message)
+}
+
+func TestWriteJSON(t *testing.T) {
+	var out bytes.Buffer
+	writeJSON("p_web", "stderr")(&out, []byte(`2019-05-01T10:00:00.000000001Z say "hi"`))
+	assert.Equal(t, `{"container":"p_web","stream":"stderr","timestamp":"2019-05-01T10:00:00.000000001Z","message":"say \"hi\""}`+"\n", out.String())
+}
@@ -1,6 +1,7 @@
 package crane
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/fatih/color"
 	shlex "github.com/flynn/go-shlex"
@@ -38,23 +40,38 @@ func handleRecoveredError(recovered interface{}) {
 		return
 	}
 
-	var statusError StatusError
+	statusError := toStatusError(recovered)
+	if statusError.error != nil {
+		printErrorf("ERROR: %s\n", statusError.error)
+	}
+	os.Exit(statusError.status)
+}
+
+// Like handleRecoveredError, but displays the error and returns
+// its status instead of exiting. Returns 0 if nothing was recovered.
+func reportRecoveredError(recovered interface{}) int {
+	if recovered == nil {
+		return 0
+	}
+
+	statusError := toStatusError(recovered)
+	if statusError.error != nil {
+		printErrorf("ERROR: %s\n", statusError.error)
+	}
+	return statusError.status
+}
 
+func toStatusError(recovered interface{}) StatusError {
 	switch err := recovered.(type) {
 	case StatusError:
-		statusError = err
+		return err
 	case error:
-		statusError = StatusError{err, 1}
+		return StatusError{err, 1}
 	case string:
-		statusError = StatusError{errors.New(err), 1}
+		return StatusError{errors.New(err), 1}
 	default:
-		statusError = StatusError{}
-	}
-
-	if statusError.error != nil {
-		printErrorf("ERROR: %s\n", statusError.error)
+		return StatusError{}
 	}
-	os.Exit(statusError.status)
 }
 
 var requiredDockerVersion = []int{1, 13}
@@ -62,8 +79,11 @@ var requiredDockerVersion = []int{1, 13}
 func RealMain() {
 	// On panic, recover the error, display it and return the given status code if any
 	defer func() {
-		handleRecoveredError(recover())
+		recovered := recover()
+		runCleanups()
+		handleRecoveredError(recovered)
 	}()
+	handleInterrupts()
 	checkDockerClient()
 	runCli()
 }
@@ -153,19 +173,69 @@ func intJoin(intSlice []int, sep string) string {
 	return strings.Join(stringSlice, sep)
 }
 
-func executeHook(hook string, containerName string) {
-	os.Setenv("CRANE_HOOKED_CONTAINER", containerName)
-	cmds, err := shlex.Split(hook)
-	if err != nil {
-		panic(StatusError{fmt.Errorf("Error when parsing hook `%v`: %v", hook, err), 64})
+// Executes all steps of a hook. The given environment variables
+// describe the context of the hook and are only passed to its commands.
+func executeHook(hook Hook, containerName string, env []string, stdout, stderr io.Writer) {
+	for _, step := range hook {
+		executeHookStep(context.Background(), step, containerName, env, stdout, stderr)
+	}
+}
+
+// Executes a single step of a hook, retrying it as configured. Depending
+// on its failure policy, a failing step aborts or is just reported.
+// Cancelling the context kills the step without reporting a failure.
+func executeHookStep(ctx context.Context, step HookStep, containerName string, env []string, stdout, stderr io.Writer) {
+	var cmds []string
+	if step.Shell {
+		cmds = []string{"sh", "-c", step.Exec()}
+	} else {
+		var err error
+		cmds, err = shlex.Split(step.Exec())
+		if err != nil {
+			panic(StatusError{fmt.Errorf("Error when parsing hook `%v`: %v", step.Exec(), err), 64})
+		}
 	}
-	switch len(cmds) {
-	case 0:
+	if len(cmds) == 0 {
 		return
-	case 1:
-		executeCommand(cmds[0], []string{}, os.Stdout, os.Stderr)
+	}
+	if step.In() == hookInContainer {
+		if len(containerName) == 0 {
+			panic(StatusError{fmt.Errorf("Hook `%v` cannot run in a container as it is not bound to one", step.Exec()), 64})
+		}
+		args := []string{"docker", "exec"}
+		for _, variable := range env {
+			args = append(args, "--env", variable)
+		}
+		cmds = append(append(args, containerName), cmds...)
+	}
+
+	var failure interface{}
+	for attempt := 0; attempt <= step.Retries; attempt++ {
+		if attempt > 0 {
+			printNoticef("Retrying hook `%s` (%d/%d) ...\n", step.Exec(), attempt, step.Retries)
+			time.Sleep(time.Second)
+		}
+		failure = func() (recovered interface{}) {
+			defer func() {
+				recovered = recover()
+			}()
+			executeCommandContext(ctx, cmds[0], cmds[1:], env, stdout, stderr, step.Timeout())
+			return
+		}()
+		// Interrupts are neither retried nor ignored
+		checkInterrupted()
+		if failure == nil || ctx.Err() != nil {
+			return
+		}
+	}
+
+	switch step.OnFailure() {
+	case hookOnFailureWarn:
+		printNoticef("WARNING: Hook `%s` failed: %v\n", step.Exec(), toStatusError(failure).error)
+	case hookOnFailureIgnore:
+		verboseMsg(fmt.Sprintf("Ignoring failure of hook `%s`: %v", step.Exec(), toStatusError(failure).error))
 	default:
-		executeCommand(cmds[0], cmds[1:], os.Stdout, os.Stderr)
+		panic(failure)
 	}
 }
 
@@ -184,17 +254,37 @@ func verboseLog(message string) {
 }
 
 func executeCommand(name string, args []string, stdout, stderr io.Writer) {
+	executeCommandContext(context.Background(), name, args, nil, stdout, stderr, 0)
+}
+
+// Like executeCommand, but adds the given variables to the environment
+// of the command, and kills the command if the context is done or if it
+// does not finish within the given (positive) timeout.
+func executeCommandContext(ctx context.Context, name string, args []string, env []string, stdout, stderr io.Writer, timeout time.Duration) {
 	verboseLog(name + " " + strings.Join(args, " "))
 	if !isDryRun() {
-		cmd := exec.Command(name, args...)
+		checkInterrupted()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		cmd := exec.CommandContext(ctx, name, args...)
 		if cfg != nil {
 			cmd.Dir = cfg.Path()
 		}
+		if len(env) > 0 {
+			cmd.Env = append(os.Environ(), env...)
+		}
 		cmd.Stdout = stdout
 		cmd.Stderr = stderr
 		cmd.Stdin = os.Stdin
-		cmd.Run()
+		runForeground(cmd)
 		if !cmd.ProcessState.Success() {
+			checkInterrupted()
+			if ctx.Err() == context.DeadlineExceeded {
+				panic(StatusError{fmt.Errorf("%s timed out after %s", name, timeout), 124})
+			}
 			status := cmd.ProcessState.Sys().(syscall.WaitStatus).ExitStatus()
 			panic(StatusError{errors.New(cmd.ProcessState.String()), status})
 		}
@@ -212,7 +302,7 @@ func executeHiddenCommand(name string, args []string) {
 func executeCommandBackground(name string, args []string) (cmd *exec.Cmd, stdout io.ReadCloser, stderr io.ReadCloser) {
 	verboseLog(name + " " + strings.Join(args, " "))
 	if !isDryRun() {
-		cmd = exec.Command(name, args...)
+		cmd = exec.CommandContext(backgroundContext, name, args...)
 		if cfg != nil {
 			cmd.Dir = cfg.Path()
 		}
@@ -239,6 +329,16 @@ func expandEnv(s string) string {
 	return os.ExpandEnv(strings.Replace(s, "$$", "${CRANE_DOLLAR}", -1))
 }
 
+// Checks whether the given RFC 3339 timestamp lies further in the
+// past than the given age. Unparsable timestamps are never too old.
+func createdBefore(timestamp string, age time.Duration) bool {
+	created, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return false
+	}
+	return time.Since(created) >= age
+}
+
 func includes(haystack []string, needle string) bool {
 	for _, name := range haystack {
 		if name == needle {
@@ -1,48 +1,251 @@
 package crane
 
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
 type Hooks interface {
-	PreBuild() string
-	PostBuild() string
-	PreStart() string
-	PostStart() string
-	PreStop() string
-	PostStop() string
+	PreBuild() Hook
+	PostBuild() Hook
+	PreCreate() Hook
+	PostCreate() Hook
+	PreStart() Hook
+	PostStart() Hook
+	PreStop() Hook
+	PostStop() Hook
+	PreRm() Hook
+	PostRm() Hook
+	PrePull() Hook
+	PostPull() Hook
+	PrePush() Hook
+	PostPush() Hook
 }
 
 type hooks struct {
-	RawPreBuild  string `json:"pre-build" yaml:"pre-build"`
-	RawPostBuild string `json:"post-build" yaml:"post-build"`
-	RawPreStart  string `json:"pre-start" yaml:"pre-start"`
-	RawPostStart string `json:"post-start" yaml:"post-start"`
-	RawPreStop   string `json:"pre-stop" yaml:"pre-stop"`
-	RawPostStop  string `json:"post-stop" yaml:"post-stop"`
-	// until we have a very long list, it's probably easier
-	// to do 4 changes in that file for each new event than
-	// using `go generate`
+	RawPreBuild   interface{} `json:"pre-build" yaml:"pre-build"`
+	RawPostBuild  interface{} `json:"post-build" yaml:"post-build"`
+	RawPreCreate  interface{} `json:"pre-create" yaml:"pre-create"`
+	RawPostCreate interface{} `json:"post-create" yaml:"post-create"`
+	RawPreStart   interface{} `json:"pre-start" yaml:"pre-start"`
+	RawPostStart  interface{} `json:"post-start" yaml:"post-start"`
+	RawPreStop    interface{} `json:"pre-stop" yaml:"pre-stop"`
+	RawPostStop   interface{} `json:"post-stop" yaml:"post-stop"`
+	RawPreRm      interface{} `json:"pre-rm" yaml:"pre-rm"`
+	RawPostRm     interface{} `json:"post-rm" yaml:"post-rm"`
+	RawPrePull    interface{} `json:"pre-pull" yaml:"pre-pull"`
+	RawPostPull   interface{} `json:"post-pull" yaml:"post-pull"`
+	RawPrePush    interface{} `json:"pre-push" yaml:"pre-push"`
+	RawPostPush   interface{} `json:"post-push" yaml:"post-push"`
+	// each new event needs an interface method, a field,
+	// an accessor and a line in `CopyFrom` and `validate`,
+	// which is still easier to follow than `go generate`
+}
+
+// Hooks which run once per command, rather than per container.
+type ProjectHooks interface {
+	BeforeUp() Hook
+	AfterUp() Hook
+	AfterDown() Hook
+}
+
+type projectHooks struct {
+	RawBeforeUp  interface{} `json:"before-up" yaml:"before-up"`
+	RawAfterUp   interface{} `json:"after-up" yaml:"after-up"`
+	RawAfterDown interface{} `json:"after-down" yaml:"after-down"`
+}
+
+// A hook consists of one or more steps, executed in order.
+type Hook []HookStep
+
+// A hook step is configured either as a plain command string,
+// or as an object giving more control over its execution.
+type HookStep struct {
+	RawExec      string `json:"exec" yaml:"exec"`
+	RawIn        string `json:"in" yaml:"in"`
+	Shell        bool   `json:"shell" yaml:"shell"`
+	RawTimeout   string `json:"timeout" yaml:"timeout"`
+	RawOnFailure string `json:"on-failure" yaml:"on-failure"`
+	Retries      int    `json:"retries" yaml:"retries"`
+	Detach       bool   `json:"detach" yaml:"detach"`
+}
+
+const (
+	hookInHost          = "host"
+	hookInContainer     = "container"
+	hookOnFailureAbort  = "abort"
+	hookOnFailureWarn   = "warn"
+	hookOnFailureIgnore = "ignore"
+)
+
+// Hooks are configured as a string, an object or
+// a list of strings and objects.
+func newHook(value interface{}) Hook {
+	hook := Hook{}
+	switch concreteValue := value.(type) {
+	case nil:
+	case string:
+		if len(concreteValue) > 0 {
+			hook = append(hook, HookStep{RawExec: concreteValue})
+		}
+	case []interface{}: // YAML or JSON: array
+		for _, v := range concreteValue {
+			hook = append(hook, newHook(v)...)
+		}
+	case map[interface{}]interface{}, map[string]interface{}: // YAML or JSON: hash
+		hook = append(hook, newHookStepFromMap(concreteValue))
+	default:
+		panic(StatusError{fmt.Errorf("unknown type: %v", value), 65})
+	}
+	return hook
+}
+
+// JSON and YAML hashes are decoded differently, so
+// the map is converted into a step via YAML.
+func newHookStepFromMap(value interface{}) HookStep {
+	var step HookStep
+	data, err := yaml.Marshal(value)
+	if err == nil {
+		err = yaml.UnmarshalStrict(data, &step)
+	}
+	if err != nil {
+		panic(StatusError{fmt.Errorf("Error when parsing hook `%v`: %v", value, err), 65})
+	}
+	switch step.In() {
+	case hookInHost, hookInContainer:
+	default:
+		panic(StatusError{fmt.Errorf("Hook `%s` must run in `host` or `container`, got `%s`", step.Exec(), step.In()), 65})
+	}
+	switch step.OnFailure() {
+	case hookOnFailureAbort, hookOnFailureWarn, hookOnFailureIgnore:
+	default:
+		panic(StatusError{fmt.Errorf("Hook `%s` must have `abort`, `warn` or `ignore` as on-failure policy, got `%s`", step.Exec(), step.OnFailure()), 65})
+	}
+	step.Timeout()
+	return step
+}
+
+func isEmptyHook(value interface{}) bool {
+	return value == nil || value == ""
+}
+
+func (s HookStep) Exec() string {
+	return expandEnv(s.RawExec)
+}
+
+// Hooks run on the host unless configured otherwise.
+func (s HookStep) In() string {
+	if len(s.RawIn) == 0 {
+		return hookInHost
+	}
+	return expandEnv(s.RawIn)
 }
 
-func (h *hooks) PreBuild() string {
-	return expandEnv(h.RawPreBuild)
+func (s HookStep) Timeout() time.Duration {
+	if len(s.RawTimeout) == 0 {
+		return 0
+	}
+	timeout, err := time.ParseDuration(expandEnv(s.RawTimeout))
+	if err != nil {
+		panic(StatusError{fmt.Errorf("Error when parsing timeout of hook `%s`: %v", s.Exec(), err), 65})
+	}
+	return timeout
 }
 
-func (h *hooks) PostBuild() string {
-	return expandEnv(h.RawPostBuild)
+// Failing hooks abort unless configured otherwise.
+func (s HookStep) OnFailure() string {
+	if len(s.RawOnFailure) == 0 {
+		return hookOnFailureAbort
+	}
+	return expandEnv(s.RawOnFailure)
 }
 
-func (h *hooks) PreStart() string {
-	return expandEnv(h.RawPreStart)
+func (h *hooks) PreBuild() Hook {
+	return newHook(h.RawPreBuild)
 }
 
-func (h *hooks) PostStart() string {
-	return expandEnv(h.RawPostStart)
+func (h *hooks) PostBuild() Hook {
+	return newHook(h.RawPostBuild)
 }
 
-func (h *hooks) PreStop() string {
-	return expandEnv(h.RawPreStop)
+func (h *hooks) PreCreate() Hook {
+	return newHook(h.RawPreCreate)
 }
 
-func (h *hooks) PostStop() string {
-	return expandEnv(h.RawPostStop)
+func (h *hooks) PostCreate() Hook {
+	return newHook(h.RawPostCreate)
+}
+
+func (h *hooks) PreStart() Hook {
+	return newHook(h.RawPreStart)
+}
+
+func (h *hooks) PostStart() Hook {
+	return newHook(h.RawPostStart)
+}
+
+func (h *hooks) PreStop() Hook {
+	return newHook(h.RawPreStop)
+}
+
+func (h *hooks) PostStop() Hook {
+	return newHook(h.RawPostStop)
+}
+
+func (h *hooks) PreRm() Hook {
+	return newHook(h.RawPreRm)
+}
+
+func (h *hooks) PostRm() Hook {
+	return newHook(h.RawPostRm)
+}
+
+func (h *hooks) PrePull() Hook {
+	return newHook(h.RawPrePull)
+}
+
+func (h *hooks) PostPull() Hook {
+	return newHook(h.RawPostPull)
+}
+
+func (h *hooks) PrePush() Hook {
+	return newHook(h.RawPrePush)
+}
+
+func (h *hooks) PostPush() Hook {
+	return newHook(h.RawPostPush)
+}
+
+// Parses all hooks, so that invalid ones are reported when the
+// configuration is loaded rather than when they are executed.
+// Only hooks which run while the container is running can run in
+// the container.
+func (h *hooks) validate(name string) {
+	h.PostStart()
+	h.PreStop()
+	validateHookOnHost(name, "pre-build", h.PreBuild())
+	validateHookOnHost(name, "post-build", h.PostBuild())
+	validateHookOnHost(name, "pre-create", h.PreCreate())
+	validateHookOnHost(name, "post-create", h.PostCreate())
+	validateHookOnHost(name, "pre-start", h.PreStart())
+	validateHookOnHost(name, "post-stop", h.PostStop())
+	validateHookOnHost(name, "pre-rm", h.PreRm())
+	validateHookOnHost(name, "post-rm", h.PostRm())
+	validateHookOnHost(name, "pre-pull", h.PrePull())
+	validateHookOnHost(name, "post-pull", h.PostPull())
+	validateHookOnHost(name, "pre-push", h.PrePush())
+	validateHookOnHost(name, "post-push", h.PostPush())
+}
+
+func validateHookOnHost(name string, event string, hook Hook) {
+	for _, step := range hook {
+		if step.In() == hookInContainer {
+			panic(StatusError{fmt.Errorf("The %s hook `%s` of `%s` cannot run in the container", event, step.Exec(), name), 65})
+		}
+	}
 }
 
 // Merge another set of hooks into the existing object. Existing
@@ -50,17 +253,65 @@ func (h *hooks) PostStop() string {
 // source struct are defined. Returns true if some content was
 // overiden in the process.
 func (h *hooks) CopyFrom(source hooks) (overridden bool) {
-	overrideIfFromNotEmpty := func(from string, to *string) {
-		if from != "" {
-			overridden = overridden || *to != ""
+	overrideIfFromNotEmpty := func(from interface{}, to *interface{}) {
+		if !isEmptyHook(from) {
+			overridden = overridden || !isEmptyHook(*to)
 			*to = from
 		}
 	}
 	overrideIfFromNotEmpty(source.RawPreBuild, &h.RawPreBuild)
 	overrideIfFromNotEmpty(source.RawPostBuild, &h.RawPostBuild)
+	overrideIfFromNotEmpty(source.RawPreCreate, &h.RawPreCreate)
+	overrideIfFromNotEmpty(source.RawPostCreate, &h.RawPostCreate)
 	overrideIfFromNotEmpty(source.RawPreStart, &h.RawPreStart)
 	overrideIfFromNotEmpty(source.RawPostStart, &h.RawPostStart)
 	overrideIfFromNotEmpty(source.RawPreStop, &h.RawPreStop)
 	overrideIfFromNotEmpty(source.RawPostStop, &h.RawPostStop)
+	overrideIfFromNotEmpty(source.RawPreRm, &h.RawPreRm)
+	overrideIfFromNotEmpty(source.RawPostRm, &h.RawPostRm)
+	overrideIfFromNotEmpty(source.RawPrePull, &h.RawPrePull)
+	overrideIfFromNotEmpty(source.RawPostPull, &h.RawPostPull)
+	overrideIfFromNotEmpty(source.RawPrePush, &h.RawPrePush)
+	overrideIfFromNotEmpty(source.RawPostPush, &h.RawPostPush)
 	return
 }
+
+func (h *projectHooks) BeforeUp() Hook {
+	return newHook(h.RawBeforeUp)
+}
+
+func (h *projectHooks) AfterUp() Hook {
+	return newHook(h.RawAfterUp)
+}
+
+func (h *projectHooks) AfterDown() Hook {
+	return newHook(h.RawAfterDown)
+}
+
+// Project hooks are not bound to a container,
+// so they always run on the host.
+func (h *projectHooks) validate() {
+	validateHookOnHost("project", "before-up", h.BeforeUp())
+	validateHookOnHost("project", "after-up", h.AfterUp())
+	validateHookOnHost("project", "after-down", h.AfterDown())
+}
+
+// Environment passed to every hook, describing the project.
+func projectHookEnv(event string) []string {
+	return []string{
+		"CRANE_HOOK_EVENT=" + event,
+		"CRANE_PREFIX=" + cfg.Prefix(),
+		"CRANE_CONFIG_PATH=" + cfg.Path(),
+	}
+}
+
+// Turns e.g. `80/tcp` into `80_TCP`, so that it can be
+// used as part of an environment variable name.
+func hookEnvName(value string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(value))
+}
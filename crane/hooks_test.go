@@ -2,6 +2,7 @@ package crane
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -41,3 +42,104 @@ func TestCopyFromReturnValue(t *testing.T) {
 	}
 	assert.True(t, target.CopyFrom(source), "Copying related hooks should trigger an override")
 }
+
+func TestCopyFromLifecycleHooks(t *testing.T) {
+	target := hooks{
+		RawPreCreate: "from target",
+		RawPostRm:    "from target",
+	}
+	source := hooks{
+		RawPreCreate: "from source",
+		RawPrePull:   "from source",
+		RawPostPush:  "from source",
+	}
+	assert.True(t, target.CopyFrom(source))
+	assert.Equal(t, "from source", target.PreCreate()[0].Exec())
+	assert.Equal(t, "from target", target.PostRm()[0].Exec())
+	assert.Equal(t, "from source", target.PrePull()[0].Exec())
+	assert.Equal(t, "from source", target.PostPush()[0].Exec())
+}
+
+func TestNewHook(t *testing.T) {
+	assert.Empty(t, newHook(nil))
+	assert.Empty(t, newHook(""))
+	assert.Equal(t, Hook{HookStep{RawExec: "echo foo"}}, newHook("echo foo"))
+
+	hook := newHook([]interface{}{
+		"echo foo",
+		map[interface{}]interface{}{
+			"exec":       "pg_isready",
+			"in":         "container",
+			"timeout":    "10s",
+			"on-failure": "warn",
+			"retries":    3,
+		},
+		map[string]interface{}{"exec": "echo bar", "shell": true},
+	})
+	assert.Len(t, hook, 3)
+	assert.Equal(t, "echo foo", hook[0].Exec())
+	assert.Equal(t, "host", hook[0].In())
+	assert.Equal(t, "abort", hook[0].OnFailure())
+	assert.Equal(t, time.Duration(0), hook[0].Timeout())
+	assert.Equal(t, "pg_isready", hook[1].Exec())
+	assert.Equal(t, "container", hook[1].In())
+	assert.Equal(t, "warn", hook[1].OnFailure())
+	assert.Equal(t, 10*time.Second, hook[1].Timeout())
+	assert.Equal(t, 3, hook[1].Retries)
+	assert.True(t, hook[2].Shell)
+
+	assert.Panics(t, func() {
+		newHook(map[string]interface{}{"exec": "echo foo", "in": "vm"})
+	})
+	assert.Panics(t, func() {
+		newHook(map[string]interface{}{"exec": "echo foo", "on-failure": "retry"})
+	})
+	assert.Panics(t, func() {
+		newHook(map[string]interface{}{"exec": "echo foo", "timeout": "soon"})
+	})
+	assert.Panics(t, func() {
+		newHook(map[string]interface{}{"exec": "echo foo", "unknown": true})
+	})
+}
+
+func TestHookEnvName(t *testing.T) {
+	assert.Equal(t, "80_TCP", hookEnvName("80/tcp"))
+	assert.Equal(t, "MY_NETWORK", hookEnvName("my-network"))
+}
+
+func TestValidateHooksInContainer(t *testing.T) {
+	inContainer := map[string]interface{}{"exec": "echo foo", "in": "container"}
+	// Only while the container is running
+	assert.NotPanics(t, func() {
+		(&hooks{RawPostStart: inContainer, RawPreStop: inContainer}).validate("a")
+	})
+	for event, h := range map[string]hooks{
+		"pre-build":   {RawPreBuild: inContainer},
+		"post-build":  {RawPostBuild: inContainer},
+		"pre-create":  {RawPreCreate: inContainer},
+		"post-create": {RawPostCreate: inContainer},
+		"pre-start":   {RawPreStart: inContainer},
+		"post-stop":   {RawPostStop: inContainer},
+		"pre-rm":      {RawPreRm: inContainer},
+		"post-rm":     {RawPostRm: inContainer},
+		"pre-pull":    {RawPrePull: inContainer},
+		"post-pull":   {RawPostPull: inContainer},
+		"pre-push":    {RawPrePush: inContainer},
+		"post-push":   {RawPostPush: inContainer},
+	} {
+		h := h
+		assert.Panics(t, func() {
+			h.validate("a")
+		}, event)
+	}
+	for event, h := range map[string]projectHooks{
+		"before-up":  {RawBeforeUp: inContainer},
+		"after-up":   {RawAfterUp: inContainer},
+		"after-down": {RawAfterDown: inContainer},
+	} {
+		h := h
+		assert.Panics(t, func() {
+			h.validate()
+		}, event)
+	}
+}
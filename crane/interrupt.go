@@ -0,0 +1,138 @@
+package crane
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+type cleanup struct {
+	f func()
+}
+
+var (
+	interruptMutex     sync.Mutex
+	interruptSignal    os.Signal
+	cleaningUp         bool
+	cleanups           []*cleanup
+	foregroundCommands = make(map[*exec.Cmd]struct{})
+	// Background commands (e.g. `docker events`) are bound to this
+	// context, so that they are killed on interrupt or when Crane exits.
+	backgroundContext, cancelBackground = context.WithCancel(context.Background())
+)
+
+// Exits Crane, replaced in tests
+var exit = os.Exit
+
+// Intercept SIGINT and SIGTERM so that Crane can clean up before exiting.
+// SIGINT sent from a terminal reaches the foreground commands directly
+// as they share the process group with Crane. Other signals are forwarded
+// to them, which in turn forwards them to attached containers.
+func handleInterrupts() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range signals {
+			handleInterrupt(sig)
+		}
+	}()
+}
+
+// A second interrupt exits right away, in
+// case cleaning up hangs or takes too long.
+func handleInterrupt(sig os.Signal) {
+	interruptMutex.Lock()
+	if interruptSignal != nil {
+		interruptMutex.Unlock()
+		cancelBackground()
+		printErrorf("ERROR: Interrupted again (%s), exiting without cleaning up\n", sig)
+		exit(interruptStatus(sig))
+		return
+	}
+	interruptSignal = sig
+	if sig != os.Interrupt {
+		for cmd := range foregroundCommands {
+			cmd.Process.Signal(sig)
+		}
+	}
+	interruptMutex.Unlock()
+	cancelBackground()
+}
+
+// Panics if Crane has been interrupted, unless cleaning up.
+func checkInterrupted() {
+	interruptMutex.Lock()
+	defer interruptMutex.Unlock()
+	if interruptSignal != nil && !cleaningUp {
+		panic(StatusError{fmt.Errorf("Interrupted (%s)", interruptSignal), interruptStatus(interruptSignal)})
+	}
+}
+
+// Exit status conventionally used by shells for the given signal
+func interruptStatus(sig os.Signal) int {
+	if sig, ok := sig.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return 130
+}
+
+// Runs a command in the foreground, allowing signals to be forwarded to it.
+func runForeground(cmd *exec.Cmd) {
+	interruptMutex.Lock()
+	err := cmd.Start()
+	if err == nil {
+		foregroundCommands[cmd] = struct{}{}
+	}
+	interruptMutex.Unlock()
+	if err != nil {
+		panic(StatusError{err, 127})
+	}
+	cmd.Wait()
+	interruptMutex.Lock()
+	delete(foregroundCommands, cmd)
+	interruptMutex.Unlock()
+}
+
+// Registers a function which runs when Crane exits, even if
+// it is interrupted or fails. The returned function removes
+// the registration again.
+func registerCleanup(f func()) (unregister func()) {
+	c := &cleanup{f: f}
+	interruptMutex.Lock()
+	cleanups = append(cleanups, c)
+	interruptMutex.Unlock()
+	return func() {
+		interruptMutex.Lock()
+		defer interruptMutex.Unlock()
+		for i, registered := range cleanups {
+			if registered == c {
+				cleanups = append(cleanups[:i], cleanups[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Runs the registered cleanups in reverse order of registration,
+// then kills all remaining background commands. Errors during
+// cleanup are reported, but do not stop the other cleanups.
+func runCleanups() {
+	interruptMutex.Lock()
+	cleaningUp = true
+	pending := cleanups
+	cleanups = nil
+	interruptMutex.Unlock()
+	for i := len(pending) - 1; i >= 0; i-- {
+		func() {
+			defer func() {
+				reportRecoveredError(recover())
+			}()
+			pending[i].f()
+		}()
+	}
+	cancelBackground()
+}
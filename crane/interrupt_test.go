@@ -0,0 +1,90 @@
+package crane
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func resetInterrupts() {
+	interruptSignal = nil
+	cleaningUp = false
+	cleanups = nil
+	backgroundContext, cancelBackground = context.WithCancel(context.Background())
+	exit = os.Exit
+}
+
+func TestRunCleanupsOrder(t *testing.T) {
+	defer resetInterrupts()
+	var order []string
+	registerCleanup(func() { order = append(order, "first") })
+	registerCleanup(func() {
+		order = append(order, "second")
+		panic(StatusError{status: 1})
+	})
+	registerCleanup(func() { order = append(order, "third") })
+	runCleanups()
+	// A failing cleanup does not stop the others
+	assert.Equal(t, []string{"third", "second", "first"}, order)
+	assert.Error(t, backgroundContext.Err())
+	// Cleanups run only once
+	order = nil
+	runCleanups()
+	assert.Empty(t, order)
+}
+
+func TestRegisterCleanupUnregister(t *testing.T) {
+	defer resetInterrupts()
+	var order []string
+	registerCleanup(func() { order = append(order, "first") })
+	unregister := registerCleanup(func() { order = append(order, "second") })
+	registerCleanup(func() { order = append(order, "third") })
+	unregister()
+	// Unregistering twice is harmless
+	unregister()
+	runCleanups()
+	assert.Equal(t, []string{"third", "first"}, order)
+}
+
+func TestCheckInterrupted(t *testing.T) {
+	defer resetInterrupts()
+	assert.NotPanics(t, checkInterrupted)
+	handleInterrupt(os.Interrupt)
+	assert.Error(t, backgroundContext.Err())
+	func() {
+		defer func() {
+			assert.Equal(t, 130, toStatusError(recover()).status)
+		}()
+		checkInterrupted()
+	}()
+	assert.Equal(t, 130, interruptStatus(os.Interrupt))
+	assert.Equal(t, 143, interruptStatus(syscall.SIGTERM))
+}
+
+func TestCleanupWhileInterrupted(t *testing.T) {
+	defer resetInterrupts()
+	handleInterrupt(syscall.SIGTERM)
+	cleanedUp := false
+	registerCleanup(func() {
+		// Cleanups are not aborted by the interrupt
+		checkInterrupted()
+		cleanedUp = true
+	})
+	runCleanups()
+	assert.True(t, cleanedUp)
+}
+
+func TestSecondInterruptExits(t *testing.T) {
+	defer resetInterrupts()
+	status := -1
+	exit = func(code int) {
+		status = code
+	}
+	handleInterrupt(os.Interrupt)
+	assert.Equal(t, -1, status)
+	handleInterrupt(syscall.SIGTERM)
+	assert.Equal(t, 143, status)
+}
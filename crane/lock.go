@@ -0,0 +1,72 @@
+package crane
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const lockFileName = "crane.lock"
+
+const lockFileHeader = "# Generated by `crane lock`. Do not edit manually.\n"
+
+// The lockfile pins pulled images to their digests, mapping
+// the configured image reference to a digest reference.
+type lockFile struct {
+	Images map[string]string `json:"images" yaml:"images"`
+}
+
+// Reads the lockfile next to the configuration.
+// Returns nil if there is none.
+func readLockFile(dir string) *lockFile {
+	filename := filepath.Join(dir, lockFileName)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		panic(StatusError{err, 74})
+	}
+	verboseMsg("Reading lockfile " + filename)
+	lock := &lockFile{}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		panic(StatusError{fmt.Errorf("Invalid lockfile %s: %v", filename, err), 65})
+	}
+	if lock.Images == nil {
+		lock.Images = make(map[string]string)
+	}
+	return lock
+}
+
+func (l *lockFile) write(dir string) {
+	filename := filepath.Join(dir, lockFileName)
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		panic(StatusError{err, 70})
+	}
+	if err := ioutil.WriteFile(filename, append([]byte(lockFileHeader), data...), 0644); err != nil {
+		panic(StatusError{err, 73})
+	}
+}
+
+// Picks the digest reference belonging to the repository of the given
+// image from the repository digests of a pulled image.
+func repoDigestFor(image string, repoDigests []string) string {
+	repository := normalizeImageName(image)
+	if at := strings.Index(repository, "@"); at != -1 {
+		repository = repository[:at]
+	} else if colon := strings.LastIndex(repository, ":"); colon > strings.LastIndex(repository, "/") {
+		repository = repository[:colon]
+	}
+	for _, repoDigest := range repoDigests {
+		parts := strings.SplitN(repoDigest, "@", 2)
+		if len(parts) == 2 && strings.TrimSuffix(normalizeImageName(parts[0]), ":latest") == repository {
+			return repoDigest
+		}
+	}
+	return ""
+}
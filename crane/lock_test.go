@@ -0,0 +1,31 @@
+package crane
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crane-lock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, readLockFile(dir))
+
+	lock := &lockFile{Images: map[string]string{"postgres:11": "postgres@sha256:aaa"}}
+	lock.write(dir)
+	assert.Equal(t, lock, readLockFile(dir))
+}
+
+func TestRepoDigestFor(t *testing.T) {
+	repoDigests := []string{"mirror.example.com/postgres@sha256:bbb", "postgres@sha256:aaa"}
+	assert.Equal(t, "postgres@sha256:aaa", repoDigestFor("postgres", repoDigests))
+	assert.Equal(t, "postgres@sha256:aaa", repoDigestFor("postgres:11", repoDigests))
+	assert.Equal(t, "postgres@sha256:aaa", repoDigestFor("docker.io/library/postgres:11", repoDigests))
+	assert.Equal(t, "mirror.example.com/postgres@sha256:bbb", repoDigestFor("mirror.example.com/postgres:11", repoDigests))
+	assert.Equal(t, "localhost:5000/app@sha256:ccc", repoDigestFor("localhost:5000/app", []string{"localhost:5000/app@sha256:ccc"}))
+	assert.Equal(t, "", repoDigestFor("mysql", repoDigests))
+}
@@ -0,0 +1,147 @@
+package crane
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Log levels accepted by `logs --level`, from least to most severe
+var logLevels = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+// Aliases of the log levels used by common logging libraries
+var logLevelAliases = map[string]string{
+	"trc":         "trace",
+	"dbg":         "debug",
+	"inf":         "info",
+	"information": "info",
+	"notice":      "info",
+	"wrn":         "warn",
+	"warning":     "warn",
+	"err":         "error",
+	"crit":        "fatal",
+	"critical":    "fatal",
+	"alert":       "fatal",
+	"emerg":       "fatal",
+	"panic":       "fatal",
+}
+
+var (
+	logfmtLevelPattern = regexp.MustCompile(`(?:^|\s)(?:level|lvl|severity)=("?)([A-Za-z]+)("?)(?:\s|$)`)
+	prefixLevelPattern = regexp.MustCompile(`^\[?([A-Za-z]+)\]?[\s:]`)
+)
+
+// Filters log lines by a regular expression and/or
+// a minimum log level.
+type logFilter struct {
+	pattern  *regexp.Regexp
+	invert   bool
+	minLevel int
+}
+
+// Returns nil if no filter is needed.
+func newLogFilter(grep string, invert bool, level string) *logFilter {
+	if len(grep) == 0 && len(level) == 0 {
+		if invert {
+			panic(StatusError{fmt.Errorf("--invert requires --grep"), 64})
+		}
+		return nil
+	}
+	filter := &logFilter{invert: invert, minLevel: -1}
+	if len(grep) > 0 {
+		pattern, err := regexp.Compile(grep)
+		if err != nil {
+			panic(StatusError{fmt.Errorf("Invalid --grep pattern: %v", err), 64})
+		}
+		filter.pattern = pattern
+	}
+	if len(level) > 0 {
+		filter.minLevel = logLevelRank(level)
+		if filter.minLevel == -1 {
+			panic(StatusError{fmt.Errorf("Unknown log level %s", level), 64})
+		}
+	}
+	return filter
+}
+
+// Wraps the given callback writing a log line, skipping lines not
+// matching the filter. Lines without a recognizable level (e.g.
+// stack traces) inherit the level of the previous line of the
+// same source.
+func (f *logFilter) wrap(write func(dest io.Writer, token []byte) (n int, err error)) func(dest io.Writer, token []byte) (n int, err error) {
+	lastLevel := -1
+	return func(dest io.Writer, token []byte) (n int, err error) {
+		_, message := splitLogLine(token)
+		if f.minLevel >= 0 {
+			if level := detectLogLevel(message); level >= 0 {
+				lastLevel = level
+			}
+			if lastLevel < f.minLevel {
+				return 0, nil
+			}
+		}
+		if f.pattern != nil && f.pattern.MatchString(message) == f.invert {
+			return 0, nil
+		}
+		return write(dest, token)
+	}
+}
+
+// Rank of the given level name, -1 if unknown
+func logLevelRank(level string) int {
+	level = strings.ToLower(level)
+	if alias, ok := logLevelAliases[level]; ok {
+		level = alias
+	}
+	for rank, name := range logLevels {
+		if name == level {
+			return rank
+		}
+	}
+	return -1
+}
+
+// Detects the level of JSON log lines (`level`, `lvl` or `severity`
+// field, also numeric as used by e.g. Bunyan), logfmt log lines and
+// lines starting with the level. Returns -1 if not recognized.
+func detectLogLevel(message string) int {
+	trimmed := strings.TrimSpace(message)
+	if strings.HasPrefix(trimmed, "{") {
+		var fields map[string]interface{}
+		if json.Unmarshal([]byte(trimmed), &fields) == nil {
+			for _, key := range []string{"level", "lvl", "severity"} {
+				switch value := fields[key].(type) {
+				case string:
+					if rank := logLevelRank(value); rank >= 0 {
+						return rank
+					}
+					if number, err := strconv.Atoi(value); err == nil {
+						return numericLogLevelRank(number)
+					}
+				case float64:
+					return numericLogLevelRank(int(value))
+				}
+			}
+			return -1
+		}
+	}
+	if match := logfmtLevelPattern.FindStringSubmatch(trimmed); match != nil && match[1] == match[3] {
+		return logLevelRank(match[2])
+	}
+	if match := prefixLevelPattern.FindStringSubmatch(trimmed); match != nil {
+		return logLevelRank(match[1])
+	}
+	return -1
+}
+
+// Bunyan and Pino use 10 (trace) to 60 (fatal)
+func numericLogLevelRank(number int) int {
+	rank := number/10 - 1
+	if rank < 0 || rank >= len(logLevels) {
+		return -1
+	}
+	return rank
+}
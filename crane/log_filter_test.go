@@ -0,0 +1,53 @@
+package crane
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectLogLevel(t *testing.T) {
+	assert.Equal(t, logLevelRank("error"), detectLogLevel(`{"level":"error","msg":"failed"}`))
+	assert.Equal(t, logLevelRank("warn"), detectLogLevel(`{"severity":"WARNING","message":"slow"}`))
+	assert.Equal(t, logLevelRank("info"), detectLogLevel(`{"level":30,"msg":"started"}`))
+	assert.Equal(t, logLevelRank("debug"), detectLogLevel(`time=2019-05-01T10:00:00Z level=debug msg="connecting"`))
+	assert.Equal(t, logLevelRank("error"), detectLogLevel(`ts=1 lvl="error" msg=failed`))
+	assert.Equal(t, logLevelRank("warn"), detectLogLevel(`[WARN] disk almost full`))
+	assert.Equal(t, logLevelRank("fatal"), detectLogLevel(`PANIC: out of memory`))
+	assert.Equal(t, -1, detectLogLevel(`    at com.example.Main.main(Main.java:3)`))
+	assert.Equal(t, -1, detectLogLevel(`{"msg":"no level"}`))
+	assert.Equal(t, -1, detectLogLevel(`starting server`))
+}
+
+func TestNewLogFilter(t *testing.T) {
+	assert.Nil(t, newLogFilter("", false, ""))
+	assert.NotNil(t, newLogFilter("foo", true, ""))
+	assert.Panics(t, func() {
+		newLogFilter("", true, "")
+	})
+	assert.Panics(t, func() {
+		newLogFilter("(", false, "")
+	})
+}
+
+func TestLogFilterWrap(t *testing.T) {
+	filtered := func(filter *logFilter, lines ...string) string {
+		var out bytes.Buffer
+		write := filter.wrap(func(dest io.Writer, token []byte) (int, error) {
+			return dest.Write(append(token, '\n'))
+		})
+		for _, line := range lines {
+			write(&out, []byte("2019-05-01T10:00:00.000000001Z "+line))
+		}
+		return out.String()
+	}
+	ts := "2019-05-01T10:00:00.000000001Z "
+
+	assert.Equal(t, ts+"error: b\n", filtered(newLogFilter("error", false, ""), "info: a", "error: b"))
+	assert.Equal(t, ts+"info: a\n", filtered(newLogFilter("error", true, ""), "info: a", "error: b"))
+	assert.Equal(t, ts+"level=error msg=b\n"+ts+"  at main.go:3\n", filtered(newLogFilter("", false, "warn"),
+		"starting", "level=info msg=a", "level=error msg=b", "  at main.go:3", "level=debug msg=c", "  at main.go:4"))
+	assert.Equal(t, ts+"level=error msg=b\n", filtered(newLogFilter("msg=b", false, "warn"), "level=error msg=b", "level=error msg=c"))
+}
@@ -0,0 +1,174 @@
+package crane
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Follows the logs of a container, replaced in tests
+var followedLogs = func(container Container, since string, until string, tail string) []LogSource {
+	return container.Logs(true, since, until, tail)
+}
+
+// Streams the starts of the given containers, one line per start
+// holding the name of the container and the time of the start in
+// nanoseconds. Returns a nil reader if the starts cannot be watched.
+// Replaced in tests.
+var containerStarts = func(names []string) (starts io.Reader, wait func()) {
+	args := []string{
+		"events",
+		"--filter", "type=container",
+		"--filter", "event=start",
+		"--format", "{{.Actor.Attributes.name}} {{.TimeNano}}",
+	}
+	for _, name := range names {
+		args = append(args, "--filter", "container="+name)
+	}
+	cmd, cmdOut, _ := executeCommandBackground("docker", args)
+	if cmd == nil {
+		return nil, nil
+	}
+	return cmdOut, func() {
+		cmd.Wait()
+	}
+}
+
+// Follows the logs of the given containers. Unlike `docker logs -f`,
+// which stops once a container stops, the logs are attached to again
+// whenever a container is restarted or recreated. Containers which do
+// not exist yet are attached to once they are started. The returned
+// sources end only if there is an `until` timestamp, or if watching
+// the events of the containers fails.
+func followLogs(containers Containers, since string, until string, tail string) (sources []LogSource) {
+	started := make(map[string]chan time.Time)
+	var names []string
+	for _, container := range containers {
+		name := container.ActualName(false)
+		names = append(names, name)
+		// Buffered, so that a start is not missed while
+		// the logs of the previous run are still being read
+		started[name] = make(chan time.Time, 1)
+	}
+	watchStarts(names, started)
+
+	for _, container := range containers {
+		var (
+			name                       = container.ActualName(false)
+			stdoutReader, stdoutWriter = io.Pipe()
+			stderrReader, stderrWriter = io.Pipe()
+		)
+		sources = append(sources, LogSource{
+			Stdout: stdoutReader,
+			Stderr: stderrReader,
+			Name:   name,
+		})
+		go func(container Container, started chan time.Time, since string, tail string) {
+			defer stdoutWriter.Close()
+			defer stderrWriter.Close()
+			var (
+				lastLine  time.Time
+				lastMutex sync.Mutex
+			)
+			// Copies line by line, remembering the
+			// timestamp of the last line
+			copyStream := func(wg *sync.WaitGroup, dest io.Writer, src io.Reader) {
+				defer wg.Done()
+				if src == nil {
+					return
+				}
+				reader := bufio.NewReader(src)
+				for {
+					line, err := reader.ReadBytes('\n')
+					if len(line) > 0 {
+						dest.Write(line)
+						timestamp, _ := splitLogLine(line)
+						if logged, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+							lastMutex.Lock()
+							if logged.After(lastLine) {
+								lastLine = logged
+							}
+							lastMutex.Unlock()
+						}
+					}
+					if err != nil {
+						return
+					}
+				}
+			}
+			for {
+				for _, log := range followedLogs(container, since, until, tail) {
+					var wg sync.WaitGroup
+					wg.Add(2)
+					go copyStream(&wg, stdoutWriter, log.Stdout)
+					go copyStream(&wg, stderrWriter, log.Stderr)
+					wg.Wait()
+					log.wait()
+				}
+				if len(until) > 0 {
+					return
+				}
+				startedAt, ok := <-started
+				if !ok {
+					return
+				}
+				// Only show what was logged after the last line
+				// shown, which is everything for recreated
+				// containers. Without any line shown yet, show
+				// what was logged since the start.
+				if lastLine.IsZero() {
+					since = startedAt.UTC().Format(time.RFC3339Nano)
+				} else {
+					since = lastLine.Add(time.Nanosecond).UTC().Format(time.RFC3339Nano)
+				}
+				tail = ""
+			}
+		}(container, started[name], since, tail)
+	}
+	return
+}
+
+// Signals the start time on the channel of the respective container
+// whenever one of the containers is started. All channels are closed
+// once the events cannot be watched anymore.
+func watchStarts(names []string, started map[string]chan time.Time) {
+	starts, wait := containerStarts(names)
+	closeAll := func() {
+		for _, ch := range started {
+			close(ch)
+		}
+	}
+	if starts == nil {
+		closeAll()
+		return
+	}
+	go func() {
+		defer closeAll()
+		scanner := bufio.NewScanner(starts)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 2 {
+				continue
+			}
+			nanoseconds, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			if ch, ok := started[strings.TrimPrefix(fields[0], "/")]; ok {
+				select {
+				case ch <- time.Unix(0, nanoseconds):
+				default:
+					// A start is pending already, the logs since
+					// that earlier start include this one
+				}
+			}
+		}
+		wait()
+		if backgroundContext.Err() == nil {
+			printNoticef("Stopped watching for restarted containers.\n")
+		}
+	}()
+}
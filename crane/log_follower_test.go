@@ -0,0 +1,103 @@
+package crane
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFollowLogsWithoutEvents(t *testing.T) {
+	defer func() {
+		*dryRunFlag = false
+	}()
+	// Nothing is executed, so the sources end right away
+	*dryRunFlag = true
+	cfg = &config{prefix: "p_"}
+	containers := Containers{&container{RawName: "a", id: "123"}, &container{RawName: "b", id: "456"}}
+	sources := followLogs(containers, "", "", "")
+	assert.Len(t, sources, 2)
+	for i, source := range sources {
+		assert.Equal(t, containers[i].ActualName(false), source.Name)
+		output, err := ioutil.ReadAll(source.Stdout)
+		assert.NoError(t, err)
+		assert.Empty(t, output)
+		output, err = ioutil.ReadAll(source.Stderr)
+		assert.NoError(t, err)
+		assert.Empty(t, output)
+	}
+}
+
+func TestFollowLogsReattaches(t *testing.T) {
+	defer func(logs func(Container, string, string, string) []LogSource, starts func([]string) (io.Reader, func())) {
+		followedLogs = logs
+		containerStarts = starts
+	}(followedLogs, containerStarts)
+	cfg = &config{prefix: "p_"}
+	type call struct{ name, since, tail string }
+	var (
+		calls                      = make(chan call, 10)
+		release                    = make(chan struct{})
+		startsReader, startsWriter = io.Pipe()
+	)
+	followedLogs = func(container Container, since string, until string, tail string) []LogSource {
+		calls <- call{container.Name(), since, tail}
+		if len(since) > 0 {
+			return nil
+		}
+		if container.Name() == "b" {
+			// Still reading the logs when b is started
+			<-release
+			return nil
+		}
+		return []LogSource{{
+			Stdout: strings.NewReader("2021-01-01T00:00:00.000000001Z one\n"),
+			Stderr: strings.NewReader("2021-01-01T00:00:00.000000002Z two\n"),
+		}}
+	}
+	containerStarts = func(names []string) (io.Reader, func()) {
+		assert.Equal(t, []string{"p_a", "p_b"}, names)
+		return startsReader, func() {}
+	}
+
+	containers := Containers{&container{RawName: "a"}, &container{RawName: "b"}}
+	sources := followLogs(containers, "", "", "10")
+	outputs := make([][]byte, 4)
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(2)
+		go func(i int, source LogSource) {
+			defer wg.Done()
+			outputs[2*i], _ = ioutil.ReadAll(source.Stdout)
+		}(i, source)
+		go func(i int, source LogSource) {
+			defer wg.Done()
+			outputs[2*i+1], _ = ioutil.ReadAll(source.Stderr)
+		}(i, source)
+	}
+
+	initial := []call{<-calls, <-calls}
+	assert.ElementsMatch(t, []call{{"a", "", "10"}, {"b", "", "10"}}, initial)
+
+	// Starts of b are coalesced while its logs are read,
+	// a is reattached after the last line it logged
+	io.WriteString(startsWriter, "p_b 1000000000\n")
+	io.WriteString(startsWriter, "/p_b 2000000000\n")
+	io.WriteString(startsWriter, "p_a 3000000000\n")
+	assert.Equal(t, call{"a", "2021-01-01T00:00:00.000000003Z", ""}, <-calls)
+
+	// Without any line, b is reattached since it was started
+	close(release)
+	assert.Equal(t, call{"b", "1970-01-01T00:00:01Z", ""}, <-calls)
+
+	startsWriter.Close()
+	wg.Wait()
+	assert.Empty(t, calls)
+	assert.Equal(t, "2021-01-01T00:00:00.000000001Z one\n", string(outputs[0]))
+	assert.Equal(t, "2021-01-01T00:00:00.000000002Z two\n", string(outputs[1]))
+	assert.Empty(t, outputs[2])
+	assert.Empty(t, outputs[3])
+}
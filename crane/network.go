@@ -1,6 +1,7 @@
 package crane
 
 import (
+	"fmt"
 	"os"
 )
 
@@ -8,13 +9,37 @@ type Network interface {
 	Name() string
 	Subnet() string
 	ActualName() string
+	External() bool
 	Create()
+	Remove()
 	Exists() bool
+	InUse() bool
 }
 
 type network struct {
-	RawName   string
-	RawSubnet string `json:"subnet" yaml:"subnet"`
+	RawName       string
+	RawSubnet     string         `json:"subnet" yaml:"subnet"`
+	RawDriver     string         `json:"driver" yaml:"driver"`
+	RawDriverOpts interface{}    `json:"driver_opts" yaml:"driver_opts"`
+	RawIpam       IpamParameters `json:"ipam" yaml:"ipam"`
+	Internal      bool           `json:"internal" yaml:"internal"`
+	Attachable    bool           `json:"attachable" yaml:"attachable"`
+	EnableIpv6    bool           `json:"enable_ipv6" yaml:"enable_ipv6"`
+	RawLabels     interface{}    `json:"labels" yaml:"labels"`
+	RawExternal   bool           `json:"external" yaml:"external"`
+}
+
+type IpamParameters struct {
+	RawDriver  string       `json:"driver" yaml:"driver"`
+	RawConfig  []IpamConfig `json:"config" yaml:"config"`
+	RawOptions interface{}  `json:"options" yaml:"options"`
+}
+
+type IpamConfig struct {
+	RawSubnet       string      `json:"subnet" yaml:"subnet"`
+	RawGateway      string      `json:"gateway" yaml:"gateway"`
+	RawIpRange      string      `json:"ip_range" yaml:"ip_range"`
+	RawAuxAddresses interface{} `json:"aux_addresses" yaml:"aux_addresses"`
 }
 
 func (n *network) Name() string {
@@ -25,21 +50,121 @@ func (n *network) Subnet() string {
 	return expandEnv(n.RawSubnet)
 }
 
+func (n *network) Driver() string {
+	return expandEnv(n.RawDriver)
+}
+
+func (n *network) DriverOpts() []string {
+	return sliceOrMap2ExpandedSlice(n.RawDriverOpts)
+}
+
+func (n *network) Labels() []string {
+	return sliceOrMap2ExpandedSlice(n.RawLabels)
+}
+
+// External networks are managed outside of Crane,
+// so they are neither prefixed, created nor removed.
+func (n *network) External() bool {
+	return n.RawExternal
+}
+
 func (n *network) ActualName() string {
+	if n.External() {
+		return n.Name()
+	}
 	return cfg.Prefix() + n.Name()
 }
 
+func (i IpamParameters) Driver() string {
+	return expandEnv(i.RawDriver)
+}
+
+func (i IpamParameters) Options() []string {
+	return sliceOrMap2ExpandedSlice(i.RawOptions)
+}
+
+func (i IpamParameters) Config() []IpamConfig {
+	return i.RawConfig
+}
+
+func (i IpamConfig) Subnet() string {
+	return expandEnv(i.RawSubnet)
+}
+
+func (i IpamConfig) Gateway() string {
+	return expandEnv(i.RawGateway)
+}
+
+func (i IpamConfig) IpRange() string {
+	return expandEnv(i.RawIpRange)
+}
+
+func (i IpamConfig) AuxAddresses() []string {
+	return sliceOrMap2ExpandedSlice(i.RawAuxAddresses)
+}
+
 func (n *network) Create() {
+	if n.External() {
+		panic(StatusError{fmt.Errorf("Network %s is external, but does not exist", n.ActualName()), 69})
+	}
 	printInfof("Creating network %s ...\n", n.ActualName())
 
-	args := []string{"network", "create"}
+	args := append([]string{"network", "create"}, n.createArgs()...)
+	executeCommand("docker", args, os.Stdout, os.Stderr)
+}
+
+// Returns all the flags to be passed to `docker network create`
+func (n *network) createArgs() []string {
+	args := []string{}
 
+	if len(n.Driver()) > 0 {
+		args = append(args, "--driver", n.Driver())
+	}
+	for _, opt := range n.DriverOpts() {
+		args = append(args, "--opt", opt)
+	}
+
+	if len(n.RawIpam.Driver()) > 0 {
+		args = append(args, "--ipam-driver", n.RawIpam.Driver())
+	}
+	for _, opt := range n.RawIpam.Options() {
+		args = append(args, "--ipam-opt", opt)
+	}
+	// The top-level subnet is kept for backwards compatibility
 	if len(n.Subnet()) > 0 {
 		args = append(args, "--subnet", n.Subnet())
 	}
+	// Docker associates gateways, ranges and auxiliary
+	// addresses with the subnet preceding them
+	for _, config := range n.RawIpam.Config() {
+		if len(config.Subnet()) > 0 {
+			args = append(args, "--subnet", config.Subnet())
+		}
+		if len(config.Gateway()) > 0 {
+			args = append(args, "--gateway", config.Gateway())
+		}
+		if len(config.IpRange()) > 0 {
+			args = append(args, "--ip-range", config.IpRange())
+		}
+		for _, auxAddress := range config.AuxAddresses() {
+			args = append(args, "--aux-address", auxAddress)
+		}
+	}
 
-	args = append(args, n.ActualName())
-	executeCommand("docker", args, os.Stdout, os.Stderr)
+	if n.Internal {
+		args = append(args, "--internal")
+	}
+	if n.Attachable {
+		args = append(args, "--attachable")
+	}
+	if n.EnableIpv6 {
+		args = append(args, "--ipv6")
+	}
+	for _, label := range n.Labels() {
+		args = append(args, "--label", label)
+	}
+
+	return append(args, n.ActualName())
 }
 
 func (n *network) Exists() bool {
@@ -47,3 +172,17 @@ func (n *network) Exists() bool {
 	_, err := commandOutput("docker", args)
 	return err == nil
 }
+
+func (n *network) Remove() {
+	printInfof("Removing network %s ...\n", n.ActualName())
+
+	args := []string{"network", "rm", n.ActualName()}
+	executeCommand("docker", args, os.Stdout, os.Stderr)
+}
+
+// A network is in use as long as containers are connected to it.
+func (n *network) InUse() bool {
+	args := []string{"network", "inspect", "--format={{len .Containers}}", n.ActualName()}
+	output, err := commandOutput("docker", args)
+	return err == nil && output != "0"
+}
@@ -0,0 +1,68 @@
+package crane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestNetworkCreateArgs(t *testing.T) {
+	cfg = &config{prefix: "p_"}
+
+	n := &network{RawName: "foo"}
+	assert.Equal(t, []string{"p_foo"}, n.createArgs())
+
+	n = &network{RawName: "foo", RawSubnet: "10.0.0.0/16"}
+	assert.Equal(t, []string{"--subnet", "10.0.0.0/16", "p_foo"}, n.createArgs())
+
+	var parsed map[string]*network
+	err := yaml.Unmarshal([]byte(`
+isolated:
+  driver: bridge
+  driver_opts:
+    com.docker.network.bridge.name: isolated0
+  ipam:
+    driver: default
+    config:
+      - subnet: 172.28.0.0/16
+        gateway: 172.28.5.254
+        ip_range: 172.28.5.0/24
+        aux_addresses:
+          host1: 172.28.1.5
+      - subnet: 2001:db8::/64
+  internal: true
+  attachable: true
+  enable_ipv6: true
+  labels:
+    - com.example=isolated
+`), &parsed)
+	assert.NoError(t, err)
+	n = parsed["isolated"]
+	n.RawName = "isolated"
+	assert.Equal(t, []string{
+		"--driver", "bridge",
+		"--opt", "com.docker.network.bridge.name=isolated0",
+		"--ipam-driver", "default",
+		"--subnet", "172.28.0.0/16",
+		"--gateway", "172.28.5.254",
+		"--ip-range", "172.28.5.0/24",
+		"--aux-address", "host1=172.28.1.5",
+		"--subnet", "2001:db8::/64",
+		"--internal",
+		"--attachable",
+		"--ipv6",
+		"--label", "com.example=isolated",
+		"p_isolated",
+	}, n.createArgs())
+}
+
+func TestExternalNetwork(t *testing.T) {
+	cfg = &config{prefix: "p_"}
+	n := &network{RawName: "shared", RawExternal: true}
+	assert.True(t, n.External())
+	assert.Equal(t, "shared", n.ActualName())
+	n = &network{RawName: "shared"}
+	assert.False(t, n.External())
+	assert.Equal(t, "p_shared", n.ActualName())
+}
@@ -0,0 +1,145 @@
+package crane
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often pulls are retried unless specified otherwise
+const defaultPullRetries = 2
+
+// Delay before the first retry, doubled for each further retry
+var pullRetryDelay = time.Second
+
+const maxPullRetryDelay = 30 * time.Second
+
+// How often the progress of pulls running in parallel is reported
+var pullProgressInterval = 10 * time.Second
+
+// Errors reported by `docker pull` which are worth retrying: timeouts
+// and dropped or refused connections. Others, e.g. unknown hosts or
+// images, or missing permissions, do not go away by retrying.
+var transientPullErrors = []string{
+	"timeout",
+	"timed out",
+	"connection reset",
+	"connection refused",
+}
+
+func isTransientPullError(output string) bool {
+	output = strings.ToLower(output)
+	for _, transientError := range transientPullErrors {
+		if strings.Contains(output, transientError) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pulls the given image, retrying with exponential
+// backoff if pulling fails for transient reasons.
+func pullWithRetries(image string, retries int, stdout, stderr io.Writer) {
+	delay := pullRetryDelay
+	for attempt := 0; ; attempt++ {
+		var errOutput bytes.Buffer
+		recovered := func() (recovered interface{}) {
+			defer func() {
+				recovered = recover()
+			}()
+			executeCommand("docker", []string{"pull", image}, stdout, io.MultiWriter(stderr, &errOutput))
+			return
+		}()
+		if recovered == nil {
+			return
+		}
+		checkInterrupted()
+		if attempt >= retries || !isTransientPullError(errOutput.String()) {
+			panic(recovered)
+		}
+		printNoticef("Pulling image %s failed, retrying in %s (%d/%d) ...\n", image, delay, attempt+1, retries)
+		select {
+		case <-time.After(delay):
+		case <-backgroundContext.Done():
+			// Interrupted while waiting
+		}
+		checkInterrupted()
+		if delay *= 2; delay > maxPullRetryDelay {
+			delay = maxPullRetryDelay
+		}
+	}
+}
+
+// Keeps track of the layers reported by `docker pull`, so that the
+// progress of pulls whose output is not shown can be reported.
+type pullProgress struct {
+	mutex   sync.Mutex
+	partial []byte
+	layers  map[string]bool
+}
+
+func (p *pullProgress) Write(data []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.partial = append(p.partial, data...)
+	for {
+		end := bytes.IndexByte(p.partial, '\n')
+		if end == -1 {
+			break
+		}
+		p.parseLine(string(p.partial[:end]))
+		p.partial = p.partial[end+1:]
+	}
+	return len(data), nil
+}
+
+// Lines about layers look like `<layer>: Pull complete`
+func (p *pullProgress) parseLine(line string) {
+	parts := strings.SplitN(strings.TrimSpace(line), ": ", 2)
+	if len(parts) != 2 || !isLayerID(parts[0]) {
+		return
+	}
+	if p.layers == nil {
+		p.layers = make(map[string]bool)
+	}
+	switch parts[1] {
+	case "Pull complete", "Already exists":
+		p.layers[parts[0]] = true
+	default:
+		if _, ok := p.layers[parts[0]]; !ok {
+			p.layers[parts[0]] = false
+		}
+	}
+}
+
+// Summary of the layers pulled so far, empty
+// as long as no layers have been reported
+func (p *pullProgress) summary() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.layers) == 0 {
+		return ""
+	}
+	complete := 0
+	for _, done := range p.layers {
+		if done {
+			complete++
+		}
+	}
+	return fmt.Sprintf("%d/%d layers", complete, len(p.layers))
+}
+
+func isLayerID(value string) bool {
+	if len(value) != 12 {
+		return false
+	}
+	for _, char := range value {
+		if !strings.ContainsRune("0123456789abcdef", char) {
+			return false
+		}
+	}
+	return true
+}
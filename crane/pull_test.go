@@ -0,0 +1,33 @@
+package crane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransientPullError(t *testing.T) {
+	assert.True(t, isTransientPullError("Error response from daemon: Get https://registry-1.docker.io/v2/: net/http: TLS handshake timeout"))
+	assert.True(t, isTransientPullError("Error response from daemon: Get https://registry-1.docker.io/v2/: net/http: request canceled while waiting for connection (Client.Timeout exceeded while awaiting headers)"))
+	assert.True(t, isTransientPullError("Error response from daemon: Get https://registry.local/v2/: dial tcp 10.0.0.1:443: connect: connection refused"))
+	assert.True(t, isTransientPullError("read tcp 10.0.0.2:51234->10.0.0.1:443: read: connection reset by peer"))
+	assert.True(t, isTransientPullError("dial tcp 10.0.0.1:443: i/o timeout"))
+	assert.False(t, isTransientPullError("Error response from daemon: Get https://registry.lcoal/v2/: dial tcp: lookup registry.lcoal: no such host"))
+	assert.False(t, isTransientPullError("Error response from daemon: toomanyrequests: You have reached your pull rate limit."))
+	assert.False(t, isTransientPullError("Error response from daemon: manifest for foo:bar not found: manifest unknown"))
+	assert.False(t, isTransientPullError("Error response from daemon: pull access denied for foo, repository does not exist"))
+	assert.False(t, isTransientPullError(""))
+}
+
+func TestPullProgress(t *testing.T) {
+	progress := &pullProgress{}
+	assert.Equal(t, "", progress.summary())
+	progress.Write([]byte("11: Pulling from library/postgres\na2abf6c4d29d: Already exists\ne1769f49f910: Pulling fs layer\n"))
+	assert.Equal(t, "1/2 layers", progress.summary())
+	// Lines may be split across writes
+	progress.Write([]byte("e1769f49f910: Downl"))
+	progress.Write([]byte("oad complete\ne1769f49f910: Pull"))
+	assert.Equal(t, "1/2 layers", progress.summary())
+	progress.Write([]byte(" complete\nDigest: sha256:4ec37d2a07a0067f176fdcc9d4bb633a5724d2cc4f892c7a2046d054bb6939e5\n"))
+	assert.Equal(t, "2/2 layers", progress.summary())
+}
@@ -0,0 +1,71 @@
+package crane
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// An image reference pushed to a registry,
+// together with the digest it was pushed as
+type PushedImage struct {
+	Reference string
+	Digest    string
+}
+
+var pushPlaceholderPattern = regexp.MustCompile(`\{\{\s*([\w.]+)\s*\}\}`)
+
+var (
+	gitValues     = make(map[string]string)
+	gitValuesLock sync.Mutex
+)
+
+// Replaces the placeholders `{{service}}`, `{{git.sha}}`,
+// `{{git.short_sha}}` and `{{git.branch}}` in the given
+// image reference.
+func expandPushReference(reference string, service string) string {
+	return pushPlaceholderPattern.ReplaceAllStringFunc(reference, func(placeholder string) string {
+		name := pushPlaceholderPattern.FindStringSubmatch(placeholder)[1]
+		switch name {
+		case "service":
+			return service
+		case "git.sha":
+			return gitValue("rev-parse", "HEAD")
+		case "git.short_sha":
+			return gitValue("rev-parse", "--short", "HEAD")
+		case "git.branch":
+			// Slashes are not allowed in tags
+			return strings.Replace(gitValue("rev-parse", "--abbrev-ref", "HEAD"), "/", "-", -1)
+		default:
+			panic(StatusError{fmt.Errorf("Unknown placeholder %s in image reference %s", placeholder, reference), 65})
+		}
+	})
+}
+
+// Whether the given image reference differs per service
+func containsServicePlaceholder(reference string) bool {
+	for _, match := range pushPlaceholderPattern.FindAllStringSubmatch(reference, -1) {
+		if match[1] == "service" {
+			return true
+		}
+	}
+	return false
+}
+
+// Output of the given git command, run in the directory of the
+// configuration. Cached as services are pushed in parallel.
+func gitValue(args ...string) string {
+	gitValuesLock.Lock()
+	defer gitValuesLock.Unlock()
+	key := strings.Join(args, " ")
+	if value, ok := gitValues[key]; ok {
+		return value
+	}
+	value, err := commandOutput("git", args)
+	if err != nil {
+		panic(StatusError{fmt.Errorf("Could not run git %s: %s", key, value), 69})
+	}
+	gitValues[key] = value
+	return value
+}
@@ -0,0 +1,28 @@
+package crane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandPushReference(t *testing.T) {
+	gitValues["rev-parse HEAD"] = "0123456789abcdef"
+	gitValues["rev-parse --abbrev-ref HEAD"] = "feature/push"
+	defer func() {
+		delete(gitValues, "rev-parse HEAD")
+		delete(gitValues, "rev-parse --abbrev-ref HEAD")
+	}()
+	assert.Equal(t, "app:latest", expandPushReference("app:latest", "web"))
+	assert.Equal(t, "registry.local/web:0123456789abcdef", expandPushReference("registry.local/{{service}}:{{git.sha}}", "web"))
+	assert.Equal(t, "web:feature-push", expandPushReference("{{ service }}:{{ git.branch }}", "web"))
+	assert.Panics(t, func() {
+		expandPushReference("app:{{version}}", "web")
+	})
+}
+
+func TestContainsServicePlaceholder(t *testing.T) {
+	assert.True(t, containsServicePlaceholder("registry.local/{{service}}:latest"))
+	assert.True(t, containsServicePlaceholder("registry.local/{{ service }}:latest"))
+	assert.False(t, containsServicePlaceholder("registry.local/app:{{git.sha}}"))
+}
@@ -7,6 +7,7 @@ import (
 type Target struct {
 	initial      []string
 	dependencies []string
+	dependents   []string
 }
 
 // NewTarget receives the specified target
@@ -68,12 +69,50 @@ func NewTarget(dependencyMap map[string]*Dependencies, targetArg string, extendF
 	return
 }
 
+// extendToDependents extends the target to all containers
+// which (transitively) depend on the initially targeted ones.
+func (t *Target) extendToDependents(dependencyMap map[string]*Dependencies) {
+	var (
+		dependentsSet  = make(map[string]struct{})
+		cascadingSeeds = t.initial
+	)
+
+	// Cascade until no further container depends on the seeds.
+	for len(cascadingSeeds) > 0 {
+		nextCascadingSeeds := []string{}
+		for _, seed := range cascadingSeeds {
+			for name, dependencies := range dependencyMap {
+				if !dependencies.includes(seed) || includes(t.initial, name) {
+					continue
+				}
+				if _, alreadyIncluded := dependentsSet[name]; !alreadyIncluded {
+					dependentsSet[name] = struct{}{}
+					nextCascadingSeeds = append(nextCascadingSeeds, name)
+				}
+			}
+		}
+		cascadingSeeds = nextCascadingSeeds
+	}
+
+	t.dependents = []string{}
+	for name := range dependentsSet {
+		if !includes(t.dependencies, name) {
+			t.dependents = append(t.dependents, name)
+		}
+	}
+
+	sort.Strings(t.dependents)
+}
+
 // Return all targeted containers, sorted alphabetically
 func (t Target) all() []string {
 	all := t.initial
 	for _, name := range t.dependencies {
 		all = append(all, name)
 	}
+	for _, name := range t.dependents {
+		all = append(all, name)
+	}
 	sort.Strings(all)
 	return all
 }
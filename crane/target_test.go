@@ -133,3 +133,27 @@ func TestDeduplicationAll(t *testing.T) {
 	target, _ := NewTarget(dependencyMap, "ab", true)
 	assert.Equal(t, []string{"a", "b", "c"}, target.all())
 }
+
+func TestExtendToDependents(t *testing.T) {
+	defer func() {
+		allowed = []string{}
+	}()
+	allowed = []string{"a", "b", "c", "d"}
+	containerMap := NewStubbedContainerMap(true,
+		&container{RawName: "a", RawNet: "bridge", RawLink: []string{"b:b"}},
+		&container{RawName: "b", RawNet: "bridge", RawLink: []string{"c:c"}},
+		&container{RawName: "c", RawNet: "bridge"},
+		&container{RawName: "d", RawNet: "bridge", RawRequires: []string{"b"}},
+	)
+	cfg = &config{containerMap: containerMap}
+	dependencyMap := cfg.DependencyMap()
+
+	target, _ := NewTarget(dependencyMap, "c", false)
+	target.extendToDependents(dependencyMap)
+	assert.Equal(t, []string{"a", "b", "d"}, target.dependents)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, target.all())
+
+	target, _ = NewTarget(dependencyMap, "a", false)
+	target.extendToDependents(dependencyMap)
+	assert.Equal(t, []string{}, target.dependents)
+}
@@ -4,14 +4,21 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 )
 
+const waitPollInterval = 500 * time.Millisecond
+
 type UnitOfWork struct {
 	targeted       []string
 	containers     []string
 	order          []string
 	requireStarted []string
+	// Volumes created by this unit of work, which are
+	// the only ones removed when tearing it down
+	createdVolumes []string
 }
 
 func NewUnitOfWork(dependencyMap map[string]*Dependencies, targeted []string) (uow *UnitOfWork, err error) {
@@ -74,26 +81,61 @@ func NewUnitOfWork(dependencyMap map[string]*Dependencies, targeted []string) (u
 }
 
 func (uow *UnitOfWork) Run(cmds []string, detach bool) {
+	uow.checkLocked()
 	uow.prepareRequirements()
 	for _, container := range uow.Containers() {
 		if includes(uow.targeted, container.Name()) {
-			container.Run(cmds, true, detach)
+			container.Run(cmds, true, detach, nil)
 		} else if includes(uow.requireStarted, container.Name()) || !container.Exists() {
 			container.Start(false)
 		}
 	}
 }
 
-func (uow *UnitOfWork) Up(cmds []string, detach bool, noCache bool, parallel int) {
-	uow.Targeted().Provision(noCache, parallel)
+func (uow *UnitOfWork) Up(cmds []string, detach bool, noCache bool, force bool, parallel int) {
+	uow.checkLocked()
+	executeHook(cfg.ProjectHooks().BeforeUp(), "", projectHookEnv("before-up"), os.Stdout, os.Stderr)
+	uow.Targeted().Provision(noCache, force, parallel)
 	uow.prepareRequirements()
-	for _, container := range uow.Containers() {
+	var afterUpOnce sync.Once
+	afterUp := func() {
+		afterUpOnce.Do(func() {
+			executeHook(cfg.ProjectHooks().AfterUp(), "", projectHookEnv("after-up"), os.Stdout, os.Stderr)
+		})
+	}
+	// Attaching blocks until the container exits, so the after-up
+	// hook runs once the first attached container has been started
+	for _, container := range uow.Containers().attachedLast(uow.targeted, cmds, detach) {
 		if includes(uow.targeted, container.Name()) {
-			container.Run(cmds, true, detach)
+			if container.Attaches(cmds, detach) {
+				container.Run(cmds, true, detach, afterUp)
+			} else {
+				container.Run(cmds, true, detach, nil)
+			}
 		} else if includes(uow.requireStarted, container.Name()) || !container.Exists() {
 			container.Start(false)
 		}
 	}
+	afterUp()
+}
+
+// Test runs the targeted containers attached, and tears down all
+// containers, networks and volumes of the unit of work afterwards,
+// even if running fails or Crane is interrupted. The exit status
+// of Crane is the one of the targeted container.
+func (uow *UnitOfWork) Test(cmds []string, noCache bool, force bool, parallel int) {
+	uow.checkLocked()
+	registerCleanup(uow.tearDown)
+	uow.Up(cmds, false, noCache, force, parallel)
+	// Containers configured to detach are still running
+	if len(cmds) == 0 {
+		for _, container := range uow.Targeted() {
+			if container.Running() {
+				uow.Wait("exited", 0)
+				break
+			}
+		}
+	}
 }
 
 func (uow *UnitOfWork) Stats(noStream bool) {
@@ -119,11 +161,29 @@ func (uow *UnitOfWork) Status(noTrunc bool) {
 	uow.Targeted().Status(noTrunc)
 }
 
+// List images.
+func (uow *UnitOfWork) Images(noTrunc bool) {
+	uow.Targeted().Images(noTrunc)
+}
+
+// Remove images.
+func (uow *UnitOfWork) RemoveImages(danglingOnly bool, unusedOnly bool, pulled bool) {
+	for _, container := range uow.Targeted().stripProvisioningDuplicates() {
+		container.RemoveImage(danglingOnly, unusedOnly, pulled)
+	}
+}
+
 // Push containers.
-func (uow *UnitOfWork) Push() {
-	for _, container := range uow.Targeted() {
-		container.Push()
+func (uow *UnitOfWork) Push(references []string, parallel int) {
+	targeted := uow.Targeted()
+	if len(targeted) > 1 {
+		for _, reference := range references {
+			if !containsServicePlaceholder(reference) {
+				panic(StatusError{fmt.Errorf("Reference %s would be pushed for several services, use {{service}} to distinguish them", reference), 64})
+			}
+		}
 	}
+	targeted.Push(references, parallel)
 }
 
 // Unpause containers.
@@ -153,12 +213,19 @@ func (uow *UnitOfWork) Start() {
 }
 
 // Stop containers.
-func (uow *UnitOfWork) Stop() {
+func (uow *UnitOfWork) Stop(timeout string) {
 	for _, container := range uow.Targeted().Reversed() {
-		container.Stop()
+		container.Stop(timeout)
 	}
 }
 
+// Restart containers. All targeted containers are stopped
+// in reverse order first, then started again in order.
+func (uow *UnitOfWork) Restart(timeout string) {
+	uow.Stop(timeout)
+	uow.Start()
+}
+
 // Kill containers.
 func (uow *UnitOfWork) Kill() {
 	for _, container := range uow.Targeted().Reversed() {
@@ -176,15 +243,105 @@ func (uow *UnitOfWork) Exec(cmds []string, privileged bool, user string) {
 	}
 }
 
-// Rm containers.
-func (uow *UnitOfWork) Rm(force bool, volumes bool) {
+// Wait until all targeted containers are running, healthy or exited.
+// When waiting for containers to exit, the first non-zero exit code
+// is used as the exit status of Crane.
+func (uow *UnitOfWork) Wait(state string, timeout time.Duration) {
+	pending := uow.Targeted()
+	printInfof("Waiting for %s to be %s ...\n", strings.Join(uow.targeted, ", "), state)
+	if isDryRun() {
+		return
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for len(pending) > 0 {
+		stillPending := Containers{}
+		for _, container := range pending {
+			var reached bool
+			switch state {
+			case "running":
+				reached = container.Running()
+			case "healthy":
+				reached = container.Healthy()
+			case "exited":
+				reached = container.Exited()
+			}
+			if reached {
+				fmt.Fprintf(container.CommandsOut(), "Container %s is %s.\n", container.ActualName(false), state)
+				continue
+			}
+			// A container which does not exist (anymore, e.g. due to
+			// --rm) cannot exit, and its exit code is unknown
+			if state == "exited" && len(containerID(container.ActualName(false))) == 0 {
+				panic(StatusError{fmt.Errorf("Container %s does not exist, cannot wait for it to be %s", container.ActualName(false), state), 69})
+			}
+			if state != "exited" && container.Exited() {
+				panic(StatusError{fmt.Errorf("Container %s exited with status %d while waiting for it to be %s", container.ActualName(false), container.ExitCode(), state), 1})
+			}
+			stillPending = append(stillPending, container)
+		}
+		pending = stillPending
+		if len(pending) == 0 {
+			break
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			names := []string{}
+			for _, container := range pending {
+				names = append(names, container.ActualName(false))
+			}
+			panic(StatusError{fmt.Errorf("Timed out after %s waiting for %s to be %s", timeout, strings.Join(names, ", "), state), 75})
+		}
+		time.Sleep(waitPollInterval)
+		checkInterrupted()
+	}
+	if state == "exited" {
+		for _, container := range uow.Targeted() {
+			if exitCode := container.ExitCode(); exitCode != 0 {
+				panic(StatusError{status: exitCode})
+			}
+		}
+	}
+}
+
+// Rm containers. The after-down hook is only executed
+// if at least one container was removed.
+func (uow *UnitOfWork) Rm(force bool, volumes bool, timeout string) {
+	removed := false
 	for _, container := range uow.Targeted().Reversed() {
-		container.Rm(force, volumes)
+		if container.Rm(force, volumes, timeout) {
+			removed = true
+		}
+	}
+	if removed {
+		executeHook(cfg.ProjectHooks().AfterDown(), "", projectHookEnv("after-down"), os.Stdout, os.Stderr)
+	}
+}
+
+// Prune removes stopped ad-hoc containers of the targeted containers.
+// Unless only ad-hoc containers should be pruned, unused sync containers
+// and volumes of the accelerated mounts they use are removed as well.
+func (uow *UnitOfWork) Prune(adHocOnly bool, olderThan time.Duration) {
+	for _, container := range uow.Targeted() {
+		container.PruneAdHoc(olderThan)
+	}
+	if !adHocOnly {
+		pruned := make(map[AcceleratedMount]bool)
+		for _, container := range uow.Targeted() {
+			for _, am := range container.AcceleratedMounts() {
+				if !pruned[am] {
+					am.Prune(olderThan)
+					pruned[am] = true
+				}
+			}
+		}
 	}
 }
 
 // Create containers.
 func (uow *UnitOfWork) Create(cmds []string) {
+	uow.checkLocked()
 	uow.prepareRequirements()
 	for _, container := range uow.Containers() {
 		if includes(uow.targeted, container.Name()) {
@@ -196,22 +353,52 @@ func (uow *UnitOfWork) Create(cmds []string) {
 }
 
 // Provision containers.
-func (uow *UnitOfWork) Provision(noCache bool, parallel int) {
-	uow.Targeted().Provision(noCache, parallel)
+func (uow *UnitOfWork) Provision(noCache bool, force bool, parallel int) {
+	uow.checkLocked()
+	uow.Targeted().Provision(noCache, force, parallel)
 }
 
 // Pull containers.
-func (uow *UnitOfWork) PullImage() {
+func (uow *UnitOfWork) PullImage(missing bool, retries int, parallel int) {
+	uow.checkLocked()
+	uow.Targeted().PullImage(missing, retries, parallel)
+}
+
+// Pin the images of the targeted containers to their digests.
+// Entries of images which are no longer configured are removed.
+func (uow *UnitOfWork) Lock() {
+	lock := readLockFile(cfg.Path())
+	if lock == nil {
+		lock = &lockFile{Images: make(map[string]string)}
+	}
+	locked := make(map[string]bool)
 	for _, container := range uow.Targeted() {
-		if len(container.BuildParams().Context()) == 0 {
-			container.PullImage()
+		image := container.UnlockedImage()
+		if len(container.BuildParams().Context()) > 0 || locked[image] {
+			continue
+		}
+		lock.Images[image] = container.LockImage()
+		locked[image] = true
+	}
+	configured := make(map[string]bool)
+	for _, container := range cfg.ContainerMap() {
+		configured[container.UnlockedImage()] = true
+	}
+	for image := range lock.Images {
+		if !configured[image] {
+			delete(lock.Images, image)
 		}
 	}
+	if isDryRun() {
+		return
+	}
+	lock.write(cfg.Path())
+	printInfof("Pinned %d image(s) in %s\n", len(locked), lockFileName)
 }
 
 // Log containers.
-func (uow *UnitOfWork) Logs(follow bool, timestamps bool, tail string, colorize bool, since string) {
-	uow.Targeted().Logs(follow, timestamps, tail, colorize, since)
+func (uow *UnitOfWork) Logs(follow bool, timestamps bool, tail string, colorize bool, since string, until string, filter *logFilter, format string, outputDir string) {
+	uow.Targeted().Logs(follow, timestamps, tail, colorize, since, until, filter, format, outputDir)
 }
 
 // Generate files.
@@ -324,6 +511,35 @@ func (uow *UnitOfWork) RequiredVolumes() []string {
 	return required
 }
 
+// With --locked, fail before doing anything if an image to be
+// pulled is not pinned in the lockfile, or if a pinned image is
+// neither present nor can be pulled.
+func (uow *UnitOfWork) checkLocked() {
+	if lockedFlag != nil && *lockedFlag {
+		for _, container := range uow.Containers() {
+			locked := container.Image()
+			if locked == container.UnlockedImage() || container.ImageExists() {
+				continue
+			}
+			// The pinned image is used regardless of where the tag
+			// points to by now, so it only has to be available
+			pulled := func() (pulled bool) {
+				defer func() {
+					if recovered := recover(); recovered != nil {
+						checkInterrupted()
+						pulled = false
+					}
+				}()
+				container.PullImage(defaultPullRetries)
+				return true
+			}()
+			if !pulled {
+				panic(StatusError{fmt.Errorf("Image %s of %s is pinned in %s, but it is not present and could not be pulled", locked, container.Name(), lockFileName), 69})
+			}
+		}
+	}
+}
+
 func (uow *UnitOfWork) ensureInContainers(name string) {
 	if !includes(uow.containers, name) {
 		uow.containers = append(uow.containers, name)
@@ -336,6 +552,66 @@ func (uow *UnitOfWork) ensureInRequireStarted(name string) {
 	}
 }
 
+// Stop and remove all containers of the unit of work, as well as
+// the networks they require and the volumes created by it. Failures are reported, but
+// do not prevent the remaining resources from being removed.
+func (uow *UnitOfWork) tearDown() {
+	tryExecute := func(f func()) {
+		defer func() {
+			reportRecoveredError(recover())
+		}()
+		f()
+	}
+	for _, container := range uow.Containers().Reversed() {
+		tryExecute(func() {
+			container.Rm(true, true, "")
+		})
+	}
+	for _, n := range uow.RequiredNetworks() {
+		net := cfg.Network(n)
+		tryExecute(func() {
+			if !net.External() && net.Exists() && !net.InUse() {
+				net.Remove()
+			}
+		})
+	}
+	for _, v := range uow.createdVolumes {
+		vol := cfg.Volume(v)
+		tryExecute(func() {
+			if vol.Exists() && !vol.InUse() {
+				vol.Remove()
+			}
+		})
+	}
+	tryExecute(func() {
+		executeHook(cfg.ProjectHooks().AfterDown(), "", projectHookEnv("after-down"), os.Stdout, os.Stderr)
+	})
+}
+
+// Stops the targeted containers which are running in reverse
+// dependency order, runs the given function, and starts them again
+// in dependency order. They are started again even if the function
+// fails or Crane is interrupted.
+func (uow *UnitOfWork) WhileStopped(f func()) {
+	stopped := Containers{}
+	unregister := registerCleanup(func() {
+		for _, container := range stopped {
+			container.Start(false)
+		}
+	})
+	for _, container := range uow.Targeted().Reversed() {
+		if container.Running() {
+			container.Stop("")
+			stopped = append(Containers{container}, stopped...)
+		}
+	}
+	f()
+	unregister()
+	for _, container := range stopped {
+		container.Start(false)
+	}
+}
+
 func (uow *UnitOfWork) prepareRequirements() {
 	uow.prepareNetworks()
 	uow.prepareVolumes()
@@ -355,6 +631,7 @@ func (uow *UnitOfWork) prepareVolumes() {
 		vol := cfg.Volume(v)
 		if !vol.Exists() {
 			vol.Create()
+			uow.createdVolumes = append(uow.createdVolumes, v)
 		}
 	}
 }
@@ -1,33 +1,173 @@
 package crane
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+// Image of the throwaway containers used to access the content of
+// volumes, pinned so that backups are created the same way everywhere
+const volumeHelperImage = "alpine:3.20"
+
+// Directory inside volumes holding new content before it replaces the old one
+const volumeTempDir = ".crane-replace"
+
 type Volume interface {
 	Name() string
 	ActualName() string
+	External() bool
 	Create()
+	Remove()
 	Exists() bool
+	InUse() bool
+	Backup(file string)
+	Restore(file string)
+	CloneTo(destination Volume)
 }
 
 type volume struct {
-	RawName string
+	RawName       string
+	RawActualName string      `json:"name" yaml:"name"`
+	RawDriver     string      `json:"driver" yaml:"driver"`
+	RawDriverOpts interface{} `json:"driver_opts" yaml:"driver_opts"`
+	RawLabels     interface{} `json:"labels" yaml:"labels"`
+	RawExternal   bool        `json:"external" yaml:"external"`
+	RawSeed       VolumeSeed  `json:"seed" yaml:"seed"`
+}
+
+// Content copied into a volume when it is created, either
+// from a host directory or from a path inside an image.
+type VolumeSeed struct {
+	RawFrom      string `json:"from" yaml:"from"`
+	RawFromImage string `json:"from-image" yaml:"from-image"`
 }
 
 func (v *volume) Name() string {
 	return expandEnv(v.RawName)
 }
 
+// Volumes are prefixed, unless they are external
+// or their actual name is configured explicitly.
 func (v *volume) ActualName() string {
+	if len(v.RawActualName) > 0 {
+		return expandEnv(v.RawActualName)
+	}
+	if v.External() {
+		return v.Name()
+	}
 	return cfg.Prefix() + v.Name()
 }
 
+func (v *volume) Driver() string {
+	return expandEnv(v.RawDriver)
+}
+
+func (v *volume) DriverOpts() []string {
+	return sliceOrMap2ExpandedSlice(v.RawDriverOpts)
+}
+
+func (v *volume) Labels() []string {
+	return sliceOrMap2ExpandedSlice(v.RawLabels)
+}
+
+// External volumes are managed outside of Crane,
+// so they are neither created nor removed.
+func (v *volume) External() bool {
+	return v.RawExternal
+}
+
 func (v *volume) Create() {
+	if v.External() {
+		panic(StatusError{fmt.Errorf("Volume %s is external, but does not exist", v.ActualName()), 69})
+	}
 	printInfof("Creating volume %s ...\n", v.ActualName())
 
-	args := []string{"volume", "create", "--name", v.ActualName()}
+	args := append([]string{"volume", "create"}, v.createArgs()...)
 	executeCommand("docker", args, os.Stdout, os.Stderr)
+	// Remove the volume again if seeding fails, so that
+	// seeding is retried when it is created next time.
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			executeHiddenCommand("docker", []string{"volume", "rm", v.ActualName()})
+			panic(recovered)
+		}
+	}()
+	v.seed()
+}
+
+// Host directory to seed the volume from, relative to the config path
+func (s VolumeSeed) From() string {
+	from := expandEnv(s.RawFrom)
+	if len(from) > 0 && !filepath.IsAbs(from) {
+		from = filepath.Join(cfg.Path(), from)
+	}
+	return from
+}
+
+// Image and path inside the image to seed the
+// volume from, configured as `image:/path`.
+func (s VolumeSeed) FromImage() (image string, path string) {
+	fromImage := expandEnv(s.RawFromImage)
+	if len(fromImage) == 0 {
+		return "", ""
+	}
+	i := strings.Index(fromImage, ":/")
+	if i < 1 {
+		panic(StatusError{fmt.Errorf("Seed `%s` must be given as `image:/path`", fromImage), 65})
+	}
+	return fromImage[:i], fromImage[i+1:]
+}
+
+// Copies the configured seed into the newly created volume.
+func (v *volume) seed() {
+	from := v.RawSeed.From()
+	image, path := v.RawSeed.FromImage()
+	if len(from) > 0 && len(image) > 0 {
+		panic(StatusError{fmt.Errorf("Volume %s can only be seeded from either a directory or an image", v.Name()), 65})
+	}
+	if len(from) > 0 {
+		printInfof("Seeding volume %s from %s ...\n", v.ActualName(), from)
+		runVolumeHelper(
+			[]string{v.ActualName() + ":/volume", from + ":/seed:ro"},
+			"cp", "-a", "/seed/.", "/volume/",
+		)
+	} else if len(image) > 0 {
+		// The volume is mounted elsewhere, as Docker would only copy
+		// the content at the mount point into an empty volume when
+		// the container starts, and not if the volume is not empty.
+		printInfof("Seeding volume %s from %s:%s ...\n", v.ActualName(), image, path)
+		executeCommand("docker", v.seedFromImageArgs(image, path), os.Stdout, os.Stderr)
+	}
+}
+
+// Returns the arguments to be passed to `docker` in order to copy
+// the given path of the image into the volume, which requires `cp`
+// to be available in the image.
+func (v *volume) seedFromImageArgs(image string, path string) []string {
+	return []string{
+		"run", "--rm",
+		"--volume", v.ActualName() + ":/crane-seed",
+		"--entrypoint", "cp",
+		image,
+		"-a", strings.TrimSuffix(path, "/") + "/.", "/crane-seed/",
+	}
+}
+
+// Returns all the flags to be passed to `docker volume create`
+func (v *volume) createArgs() []string {
+	args := []string{"--name", v.ActualName()}
+	if len(v.Driver()) > 0 {
+		args = append(args, "--driver", v.Driver())
+	}
+	for _, opt := range v.DriverOpts() {
+		args = append(args, "--opt", opt)
+	}
+	for _, label := range v.Labels() {
+		args = append(args, "--label", label)
+	}
+	return args
 }
 
 func (v *volume) Exists() bool {
@@ -35,3 +175,100 @@ func (v *volume) Exists() bool {
 	_, err := commandOutput("docker", args)
 	return err == nil
 }
+
+func (v *volume) Remove() {
+	printInfof("Removing volume %s ...\n", v.ActualName())
+
+	args := []string{"volume", "rm", v.ActualName()}
+	executeCommand("docker", args, os.Stdout, os.Stderr)
+}
+
+// A volume is in use as long as any container, running or
+// not, references it.
+func (v *volume) InUse() bool {
+	args := []string{"ps", "--all", "--quiet", "--filter", "volume=" + v.ActualName()}
+	output, err := commandOutput("docker", args)
+	return err == nil && len(output) > 0
+}
+
+// Writes the content of the volume into a gzipped tarball.
+func (v *volume) Backup(file string) {
+	v.ensureExists()
+	dir, base := absolutePathParts(file)
+	printInfof("Backing up volume %s to %s ...\n", v.ActualName(), file)
+	runVolumeHelper(
+		[]string{v.ActualName() + ":/volume:ro", dir + ":/backup"},
+		"tar", "-czf", "/backup/"+base, "-C", "/volume", ".",
+	)
+}
+
+// Replaces the content of the volume with the
+// content of a gzipped tarball created by Backup.
+func (v *volume) Restore(file string) {
+	dir, base := absolutePathParts(file)
+	printInfof("Restoring volume %s from %s ...\n", v.ActualName(), file)
+	runVolumeHelper(
+		[]string{v.ActualName() + ":/volume", dir + ":/backup:ro"},
+		"sh", "-c", replaceContentScript("/volume", func(tmp string) string {
+			return "tar -xzf \"/backup/$0\" -C " + tmp
+		}), base,
+	)
+}
+
+// Replaces the content of the destination
+// volume with the content of this volume.
+func (v *volume) CloneTo(destination Volume) {
+	v.ensureExists()
+	if v.ActualName() == destination.ActualName() {
+		panic(StatusError{fmt.Errorf("Cannot clone volume %s into itself", v.ActualName()), 64})
+	}
+	printInfof("Cloning volume %s into %s ...\n", v.ActualName(), destination.ActualName())
+	runVolumeHelper(
+		[]string{v.ActualName() + ":/from:ro", destination.ActualName() + ":/to"},
+		"sh", "-c", replaceContentScript("/to", func(tmp string) string {
+			return "cp -a /from/. " + tmp + "/"
+		}),
+	)
+}
+
+// Returns a shell script replacing the content of the given directory
+// with what the command returned by `fill` writes into the given
+// temporary directory. The temporary directory is used, so that
+// the existing content is kept if it fails (e.g. due to a corrupt
+// backup).
+func replaceContentScript(dir string, fill func(tmp string) string) string {
+	tmp := dir + "/" + volumeTempDir
+	return fmt.Sprintf(
+		"rm -rf %[1]s && mkdir %[1]s && { %[3]s || { rm -rf %[1]s; exit 1; }; } && "+
+			"find %[2]s -mindepth 1 -maxdepth 1 ! -name %[4]s -exec rm -rf {} + && "+
+			"find %[1]s -mindepth 1 -maxdepth 1 -exec mv {} %[2]s/ \\; && rmdir %[1]s",
+		tmp, dir, fill(tmp), volumeTempDir,
+	)
+}
+
+func (v *volume) ensureExists() {
+	if !v.Exists() {
+		panic(StatusError{fmt.Errorf("Volume %s does not exist", v.ActualName()), 66})
+	}
+}
+
+// Runs the given command in a throwaway container with the given volumes mounted
+func runVolumeHelper(volumes []string, cmds ...string) {
+	args := []string{"run", "--rm"}
+	for _, volume := range volumes {
+		args = append(args, "--volume", volume)
+	}
+	args = append(args, volumeHelperImage)
+	args = append(args, cmds...)
+	executeCommand("docker", args, os.Stdout, os.Stderr)
+}
+
+// Splits the given path, relative to the working directory,
+// into its absolute directory and its base name.
+func absolutePathParts(path string) (dir string, base string) {
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		panic(StatusError{err, 64})
+	}
+	return filepath.Dir(absolutePath), filepath.Base(absolutePath)
+}
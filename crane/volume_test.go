@@ -0,0 +1,95 @@
+package crane
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolumeActualName(t *testing.T) {
+	cfg = &config{prefix: "p_"}
+	assert.Equal(t, "p_cache", (&volume{RawName: "cache"}).ActualName())
+	assert.Equal(t, "cache", (&volume{RawName: "cache", RawExternal: true}).ActualName())
+	assert.Equal(t, "shared-cache", (&volume{RawName: "cache", RawActualName: "shared-cache"}).ActualName())
+	assert.Equal(t, "shared-cache", (&volume{RawName: "cache", RawActualName: "shared-cache", RawExternal: true}).ActualName())
+}
+
+func TestVolumeCreateArgs(t *testing.T) {
+	cfg = &config{prefix: "p_"}
+	v := &volume{RawName: "data"}
+	assert.Equal(t, []string{"--name", "p_data"}, v.createArgs())
+
+	v = &volume{
+		RawName:       "tmp",
+		RawDriver:     "local",
+		RawDriverOpts: map[interface{}]interface{}{"type": "tmpfs"},
+		RawLabels:     []interface{}{"com.example=tmp"},
+	}
+	assert.Equal(t, []string{"--name", "p_tmp", "--driver", "local", "--opt", "type=tmpfs", "--label", "com.example=tmp"}, v.createArgs())
+}
+
+func TestAbsolutePathParts(t *testing.T) {
+	dir, base := absolutePathParts("/backups/db.tar.gz")
+	assert.Equal(t, "/backups", dir)
+	assert.Equal(t, "db.tar.gz", base)
+
+	wd, _ := os.Getwd()
+	dir, base = absolutePathParts("db.tar.gz")
+	assert.Equal(t, wd, dir)
+	assert.Equal(t, "db.tar.gz", base)
+}
+
+func TestVolumeSeed(t *testing.T) {
+	cfg = &config{path: "/project"}
+	assert.Equal(t, "", VolumeSeed{}.From())
+	assert.Equal(t, "/project/fixtures", VolumeSeed{RawFrom: "./fixtures"}.From())
+	assert.Equal(t, "/fixtures", VolumeSeed{RawFrom: "/fixtures"}.From())
+
+	image, path := VolumeSeed{}.FromImage()
+	assert.Equal(t, "", image)
+	assert.Equal(t, "", path)
+	image, path = VolumeSeed{RawFromImage: "registry:5000/fixtures:1.0:/data"}.FromImage()
+	assert.Equal(t, "registry:5000/fixtures:1.0", image)
+	assert.Equal(t, "/data", path)
+	assert.Panics(t, func() {
+		VolumeSeed{RawFromImage: "fixtures"}.FromImage()
+	})
+
+	cfg = &config{prefix: "p_"}
+	v := &volume{RawName: "data"}
+	assert.Equal(t, []string{
+		"run", "--rm",
+		"--volume", "p_data:/crane-seed",
+		"--entrypoint", "cp",
+		"fixtures:1.0",
+		"-a", "/data/.", "/crane-seed/",
+	}, v.seedFromImageArgs("fixtures:1.0", "/data/"))
+}
+
+func TestReplaceContentScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crane-volume")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	volume := filepath.Join(dir, "volume")
+	assert.NoError(t, os.MkdirAll(filepath.Join(volume, "old"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(volume, ".hidden"), []byte("old"), 0644))
+
+	// Existing content is kept if filling fails
+	script := replaceContentScript(volume, func(tmp string) string {
+		return "touch " + tmp + "/partial && false"
+	})
+	assert.Error(t, exec.Command("/bin/sh", "-c", script).Run())
+	files, _ := filepath.Glob(filepath.Join(volume, "*"))
+	assert.Equal(t, []string{filepath.Join(volume, ".hidden"), filepath.Join(volume, "old")}, files)
+
+	script = replaceContentScript(volume, func(tmp string) string {
+		return "mkdir " + tmp + "/new && touch " + tmp + "/.dotfile"
+	})
+	assert.NoError(t, exec.Command("/bin/sh", "-c", script).Run())
+	files, _ = filepath.Glob(filepath.Join(volume, "*"))
+	assert.Equal(t, []string{filepath.Join(volume, ".dotfile"), filepath.Join(volume, "new")}, files)
+}